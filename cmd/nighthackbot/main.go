@@ -1,6 +1,11 @@
 package main
 
-import "github.com/alufers/nighthack-bot/pkg/nighthackbot"
+import (
+	// embed the time zone database so that schedules work without system tzdata
+	_ "time/tzdata"
+
+	"github.com/alufers/nighthack-bot/pkg/nighthackbot"
+)
 
 func main() {
 	nighthackbot.Run()
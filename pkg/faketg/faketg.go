@@ -0,0 +1,463 @@
+// Package faketg is an in-memory stand-in for the Telegram Bot API, for running the bot in tests without network access.
+// Point tgbotapi.NewBotAPIWithAPIEndpoint at Server.Endpoint, then script the users with SendMessage and PressButton
+// and check what the bot sent with BotMessages.
+package faketg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxPollWait is the longest getUpdates waits for new updates, shorter than Telegram so that tests stop quickly.
+const MaxPollWait = time.Second
+
+// CallbackAnswer is an answerCallbackQuery call.
+type CallbackAnswer struct {
+	CallbackID string
+	Text       string
+}
+
+// Server is a fake Bot API server. It keeps the messages of all the chats, including the ones of the users.
+type Server struct {
+	Token string
+	Bot   tgbotapi.User
+
+	server *httptest.Server
+
+	mutex         sync.Mutex
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	chats         map[int64]*tgbotapi.Chat
+	messages      []*tgbotapi.Message
+	deleted       map[*tgbotapi.Message]bool
+	commands      []tgbotapi.BotCommand
+	callbacks     []CallbackAnswer
+	calls         map[string]int
+	// changed is closed and replaced on every change of the state
+	changed chan struct{}
+}
+
+// NewServer starts a fake Bot API server. It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		Token:         "123456:fake-token",
+		Bot:           tgbotapi.User{ID: 123456, IsBot: true, FirstName: "Fake bot", UserName: "fakebot"},
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		chats:         map[int64]*tgbotapi.Chat{},
+		deleted:       map[*tgbotapi.Message]bool{},
+		calls:         map[string]int{},
+		changed:       make(chan struct{}),
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// Endpoint is the API endpoint format for tgbotapi.NewBotAPIWithAPIEndpoint.
+func (s *Server) Endpoint() string {
+	return s.server.URL + "/bot%s/%s"
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// notifyLocked wakes up everyone waiting for a change.
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor waits until the check returns true, it is called with the mutex locked.
+func (s *Server) waitFor(timeout time.Duration, check func() bool) bool {
+	deadline := time.After(timeout)
+	for {
+		s.mutex.Lock()
+		if check() {
+			s.mutex.Unlock()
+			return true
+		}
+		changed := s.changed
+		s.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+func (s *Server) chatLocked(chat tgbotapi.Chat) *tgbotapi.Chat {
+	if existing, ok := s.chats[chat.ID]; ok {
+		return existing
+	}
+	if chat.Type == "" {
+		chat.Type = "private"
+	}
+	s.chats[chat.ID] = &chat
+	return &chat
+}
+
+func (s *Server) addMessageLocked(chat *tgbotapi.Chat, from *tgbotapi.User, text string) *tgbotapi.Message {
+	msg := &tgbotapi.Message{
+		MessageID: s.nextMessageID,
+		From:      from,
+		Chat:      chat,
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	s.nextMessageID++
+	s.messages = append(s.messages, msg)
+	return msg
+}
+
+func (s *Server) messageLocked(chatID int64, messageID int) *tgbotapi.Message {
+	for _, msg := range s.messages {
+		if msg.Chat.ID == chatID && msg.MessageID == messageID && !s.deleted[msg] {
+			return msg
+		}
+	}
+	return nil
+}
+
+func (s *Server) addUpdateLocked(update tgbotapi.Update) {
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+	s.notifyLocked()
+}
+
+// SendMessage makes the user send a text message to the chat, replying to a message if replyTo is not nil.
+func (s *Server) SendMessage(chat tgbotapi.Chat, from tgbotapi.User, text string, replyTo *tgbotapi.Message) tgbotapi.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	msg := s.addMessageLocked(s.chatLocked(chat), &from, text)
+	if strings.HasPrefix(text, "/") {
+		command := strings.Fields(text)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	if replyTo != nil {
+		msg.ReplyToMessage = s.messageLocked(replyTo.Chat.ID, replyTo.MessageID)
+	}
+	s.addUpdateLocked(tgbotapi.Update{Message: msg})
+	return *msg
+}
+
+// PressButton makes the user press an inline button with the data on a message of the bot and returns the callback ID.
+func (s *Server) PressButton(from tgbotapi.User, msg tgbotapi.Message, data string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	callbackID := strconv.Itoa(s.nextUpdateID)
+	message := s.messageLocked(msg.Chat.ID, msg.MessageID)
+	if message == nil {
+		message = &msg
+	}
+	s.addUpdateLocked(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:           callbackID,
+		From:         &from,
+		Message:      message,
+		ChatInstance: strconv.FormatInt(msg.Chat.ID, 10),
+		Data:         data,
+	}})
+	return callbackID
+}
+
+// BotMessages returns the messages the bot has sent to the chat and not deleted, with the edits applied.
+func (s *Server) BotMessages(chatID int64) []tgbotapi.Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.botMessagesLocked(chatID)
+}
+
+func (s *Server) botMessagesLocked(chatID int64) []tgbotapi.Message {
+	messages := []tgbotapi.Message{}
+	for _, msg := range s.messages {
+		if msg.Chat.ID == chatID && msg.From != nil && msg.From.ID == s.Bot.ID && !s.deleted[msg] {
+			messages = append(messages, *msg)
+		}
+	}
+	return messages
+}
+
+// WaitForBotMessage waits until the bot has a message in the chat matching the function.
+func (s *Server) WaitForBotMessage(chatID int64, timeout time.Duration, match func(msg tgbotapi.Message) bool) (tgbotapi.Message, error) {
+	var found tgbotapi.Message
+	ok := s.waitFor(timeout, func() bool {
+		for _, msg := range s.botMessagesLocked(chatID) {
+			if match(msg) {
+				found = msg
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		return found, fmt.Errorf("timed out after %v waiting for a message of the bot in chat %d", timeout, chatID)
+	}
+	return found, nil
+}
+
+// IsDeleted reports whether the message has been deleted.
+func (s *Server) IsDeleted(msg tgbotapi.Message) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, m := range s.messages {
+		if m.Chat.ID == msg.Chat.ID && m.MessageID == msg.MessageID {
+			return s.deleted[m]
+		}
+	}
+	return false
+}
+
+// WaitForCallbackAnswer waits until the callback has been answered.
+func (s *Server) WaitForCallbackAnswer(callbackID string, timeout time.Duration) (CallbackAnswer, error) {
+	var found CallbackAnswer
+	ok := s.waitFor(timeout, func() bool {
+		for _, answer := range s.callbacks {
+			if answer.CallbackID == callbackID {
+				found = answer
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		return found, fmt.Errorf("timed out after %v waiting for the answer of callback %v", timeout, callbackID)
+	}
+	return found, nil
+}
+
+// Commands returns the commands set with setMyCommands.
+func (s *Server) Commands() []tgbotapi.BotCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]tgbotapi.BotCommand{}, s.commands...)
+}
+
+// Calls returns how many times the method has been called.
+func (s *Server) Calls(method string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.calls[method]
+}
+
+// ServeHTTP handles the Bot API requests, which are made to /bot<token>/<method>.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/bot"), "/", 2)
+	if len(parts) != 2 || parts[0] != s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	method := parts[1]
+	s.mutex.Lock()
+	s.calls[method]++
+	s.mutex.Unlock()
+
+	var result interface{}
+	var err error
+	switch method {
+	case "getMe":
+		result = s.Bot
+	case "getUpdates":
+		result, err = s.getUpdates(r)
+	case "deleteWebhook":
+		result = true
+	case "setMyCommands":
+		result, err = s.setMyCommands(r)
+	case "sendMessage":
+		result, err = s.sendMessage(r)
+	case "editMessageText":
+		result, err = s.editMessageText(r)
+	case "deleteMessage":
+		result, err = s.deleteMessage(r)
+	case "answerCallbackQuery":
+		result, err = s.answerCallbackQuery(r)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method "+method+" is not implemented by faketg")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	writeResult(w, result)
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
+
+func formInt64(r *http.Request, key string) (int64, error) {
+	value, err := strconv.ParseInt(r.Form.Get(key), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v %q", key, r.Form.Get(key))
+	}
+	return value, nil
+}
+
+// getUpdates returns the updates encoded while the mutex is locked, because they point to messages which can be edited.
+func (s *Server) getUpdates(r *http.Request) (json.RawMessage, error) {
+	offset, _ := strconv.Atoi(r.Form.Get("offset"))
+	timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+	wait := time.Duration(timeout) * time.Second
+	if wait > MaxPollWait {
+		wait = MaxPollWait
+	}
+	var raw json.RawMessage
+	var err error
+	found := s.waitFor(wait, func() bool {
+		// like Telegram, the updates before the offset are confirmed and forgotten
+		pending := []tgbotapi.Update{}
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				pending = append(pending, update)
+			}
+		}
+		s.updates = pending
+		if len(pending) == 0 {
+			return false
+		}
+		raw, err = json.Marshal(pending)
+		return true
+	})
+	if !found {
+		return json.RawMessage("[]"), nil
+	}
+	return raw, err
+}
+
+func (s *Server) setMyCommands(r *http.Request) (bool, error) {
+	commands := []tgbotapi.BotCommand{}
+	if err := json.Unmarshal([]byte(r.Form.Get("commands")), &commands); err != nil {
+		return false, fmt.Errorf("invalid commands: %w", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.commands = commands
+	s.notifyLocked()
+	return true, nil
+}
+
+// copyMessage returns a copy of the message which can be encoded after the mutex is unlocked.
+func copyMessage(msg *tgbotapi.Message) *tgbotapi.Message {
+	copied := *msg
+	if msg.ReplyToMessage != nil {
+		replyTo := *msg.ReplyToMessage
+		replyTo.ReplyToMessage = nil
+		copied.ReplyToMessage = &replyTo
+	}
+	return &copied
+}
+
+func parseInlineKeyboard(raw string) *tgbotapi.InlineKeyboardMarkup {
+	if raw == "" {
+		return nil
+	}
+	markup := &tgbotapi.InlineKeyboardMarkup{}
+	if err := json.Unmarshal([]byte(raw), markup); err != nil || len(markup.InlineKeyboard) == 0 {
+		// other keyboards, like ForceReply, are not kept
+		return nil
+	}
+	return markup
+}
+
+func (s *Server) sendMessage(r *http.Request) (*tgbotapi.Message, error) {
+	chatID, err := formInt64(r, "chat_id")
+	if err != nil {
+		return nil, err
+	}
+	text := r.Form.Get("text")
+	if text == "" {
+		return nil, fmt.Errorf("message text is empty")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	msg := s.addMessageLocked(s.chatLocked(tgbotapi.Chat{ID: chatID}), &s.Bot, text)
+	msg.ReplyMarkup = parseInlineKeyboard(r.Form.Get("reply_markup"))
+	if replyTo, err := formInt64(r, "reply_to_message_id"); err == nil {
+		msg.ReplyToMessage = s.messageLocked(chatID, int(replyTo))
+		if msg.ReplyToMessage == nil && r.Form.Get("allow_sending_without_reply") != "true" {
+			return nil, fmt.Errorf("replied message not found")
+		}
+	}
+	s.notifyLocked()
+	return copyMessage(msg), nil
+}
+
+func (s *Server) editMessageText(r *http.Request) (*tgbotapi.Message, error) {
+	chatID, err := formInt64(r, "chat_id")
+	if err != nil {
+		return nil, err
+	}
+	messageID, err := formInt64(r, "message_id")
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	msg := s.messageLocked(chatID, int(messageID))
+	if msg == nil || msg.From == nil || msg.From.ID != s.Bot.ID {
+		return nil, fmt.Errorf("message to edit not found")
+	}
+	msg.Text = r.Form.Get("text")
+	msg.ReplyMarkup = parseInlineKeyboard(r.Form.Get("reply_markup"))
+	msg.EditDate = int(time.Now().Unix())
+	s.notifyLocked()
+	return copyMessage(msg), nil
+}
+
+func (s *Server) deleteMessage(r *http.Request) (bool, error) {
+	chatID, err := formInt64(r, "chat_id")
+	if err != nil {
+		return false, err
+	}
+	messageID, err := formInt64(r, "message_id")
+	if err != nil {
+		return false, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	msg := s.messageLocked(chatID, int(messageID))
+	if msg == nil {
+		return false, fmt.Errorf("message to delete not found")
+	}
+	s.deleted[msg] = true
+	s.notifyLocked()
+	return true, nil
+}
+
+func (s *Server) answerCallbackQuery(r *http.Request) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.callbacks = append(s.callbacks, CallbackAnswer{
+		CallbackID: r.Form.Get("callback_query_id"),
+		Text:       r.Form.Get("text"),
+	})
+	s.notifyLocked()
+	return true, nil
+}
@@ -0,0 +1,248 @@
+package nighthackbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ArgumentType parses and validates the values of a command argument.
+type ArgumentType interface {
+	// Parse converts the input of the user, the error is shown to the user who is then asked again.
+	Parse(args *CommandArguments, input string) (interface{}, error)
+	// Hint describes the expected input, it is shown with the question. It can be empty.
+	Hint() string
+}
+
+// ArgumentSuggester is implemented by the argument types which offer buttons with the allowed values.
+type ArgumentSuggester interface {
+	Suggestions() map[string]string
+}
+
+// StringArgument accepts any input, it is the type of arguments without a Type.
+type StringArgument struct{}
+
+func (StringArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	return input, nil
+}
+
+func (StringArgument) Hint() string {
+	return ""
+}
+
+// IntArgument accepts integers, optionally limited to a range.
+type IntArgument struct {
+	Min *int64
+	Max *int64
+}
+
+// IntRange returns an IntArgument accepting values from min to max.
+func IntRange(min int64, max int64) IntArgument {
+	return IntArgument{Min: &min, Max: &max}
+}
+
+func (t IntArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	val, err := strconv.ParseInt(strings.TrimSpace(input), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number", input)
+	}
+	if (t.Min != nil && val < *t.Min) || (t.Max != nil && val > *t.Max) {
+		return nil, fmt.Errorf("expected a number %v", t.Hint())
+	}
+	return val, nil
+}
+
+func (t IntArgument) Hint() string {
+	switch {
+	case t.Min != nil && t.Max != nil:
+		return fmt.Sprintf("from %d to %d", *t.Min, *t.Max)
+	case t.Min != nil:
+		return fmt.Sprintf("at least %d", *t.Min)
+	case t.Max != nil:
+		return fmt.Sprintf("at most %d", *t.Max)
+	}
+	return "a number"
+}
+
+// UserArgument accepts a telegram user ID or the @username of a user known to the bot and returns a *User.
+// Forwarded messages and shared contacts are answered with the ID of their user.
+// Users referenced by ID who have not talked to the bot yet are returned unsaved.
+type UserArgument struct{}
+
+func (UserArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	input = strings.TrimSpace(input)
+	user := &User{}
+	if strings.HasPrefix(input, "@") {
+		username := strings.TrimPrefix(input, "@")
+		if err := args.BotApp.DB.Where("lower(username) = lower(?)", username).First(user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("unknown user @%v, they have to write to the bot first or you can use their ID", username)
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+	telegramID, err := strconv.ParseInt(input, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("expected a user ID or @username, forward a message of the user or share their contact")
+	}
+	if err := args.BotApp.DB.Where("telegram_id = ?", telegramID).First(user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		user.TelegramID = telegramID
+	}
+	return user, nil
+}
+
+func (UserArgument) Hint() string {
+	return "user ID, @username, a forwarded message or a contact"
+}
+
+// DurationArgument accepts non-negative durations like 2h30m.
+type DurationArgument struct{}
+
+func (DurationArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	if err := validateDurationSetting(strings.TrimSpace(input)); err != nil {
+		return nil, err
+	}
+	return time.ParseDuration(strings.TrimSpace(input))
+}
+
+func (DurationArgument) Hint() string {
+	return "like 2h30m"
+}
+
+// TimeArgument accepts a date and time in the time zone of the space.
+type TimeArgument struct {
+	// Future rejects times which have already passed.
+	Future bool
+}
+
+func (t TimeArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	val, err := args.BotApp.SchedulerService.ParseTime(input)
+	if err != nil {
+		return nil, err
+	}
+	if t.Future && !val.After(time.Now()) {
+		return nil, fmt.Errorf("the time must be in the future")
+	}
+	return val, nil
+}
+
+func (TimeArgument) Hint() string {
+	return nighthackTimeInputLayout
+}
+
+// DateArgument accepts a date, returned as midnight UTC like the dates of schedule expressions.
+type DateArgument struct{}
+
+func (DateArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	val, err := time.Parse(scheduleDateLayout, strings.TrimSpace(input))
+	if err != nil {
+		return nil, fmt.Errorf("expected a date like %v", scheduleDateLayout)
+	}
+	return val, nil
+}
+
+func (DateArgument) Hint() string {
+	return scheduleDateLayout
+}
+
+// ScheduleArgument accepts a schedule expression and returns a *ScheduleExpression.
+type ScheduleArgument struct{}
+
+func (ScheduleArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	expr, err := ParseScheduleExpression(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule expression: %w", err)
+	}
+	return expr, nil
+}
+
+func (ScheduleArgument) Hint() string {
+	return "like friday 18:00 or cron 0 18 * * 5"
+}
+
+// EnumValue is one of the allowed values of an EnumArgument.
+type EnumValue struct {
+	Value string
+	Label string // the text of the suggestion button
+}
+
+// EnumArgument accepts one of the values and returns it as a string.
+type EnumArgument struct {
+	Values []EnumValue
+}
+
+func (t EnumArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	for _, value := range t.Values {
+		if strings.EqualFold(strings.TrimSpace(input), value.Value) {
+			return value.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("expected one of: %v", t.Hint())
+}
+
+func (t EnumArgument) Hint() string {
+	values := []string{}
+	for _, value := range t.Values {
+		values = append(values, value.Value)
+	}
+	return strings.Join(values, ", ")
+}
+
+func (t EnumArgument) Suggestions() map[string]string {
+	suggestions := map[string]string{}
+	for _, value := range t.Values {
+		suggestions[value.Value] = value.Label
+	}
+	return suggestions
+}
+
+// BoolArgument accepts yes/no answers.
+type BoolArgument struct{}
+
+var boolArgumentValues = map[string]bool{
+	"yes": true, "y": true, "true": true, "on": true, "1": true,
+	"no": false, "n": false, "false": false, "off": false, "0": false,
+}
+
+func (BoolArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	val, ok := boolArgumentValues[strings.ToLower(strings.TrimSpace(input))]
+	if !ok {
+		return nil, fmt.Errorf("expected yes or no")
+	}
+	return val, nil
+}
+
+func (BoolArgument) Hint() string {
+	return "yes or no"
+}
+
+func (BoolArgument) Suggestions() map[string]string {
+	return map[string]string{"yes": "✅ Yes", "no": "❌ No"}
+}
+
+// ValidatedArgument accepts the strings accepted by Validate, like the values of a setting.
+type ValidatedArgument struct {
+	Validate    func(value string) error
+	Description string
+}
+
+func (t ValidatedArgument) Parse(args *CommandArguments, input string) (interface{}, error) {
+	if t.Validate != nil {
+		if err := t.Validate(input); err != nil {
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+func (t ValidatedArgument) Hint() string {
+	return t.Description
+}
@@ -0,0 +1,61 @@
+package nighthackbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArgumentTypes(t *testing.T) {
+	enum := EnumArgument{Values: []EnumValue{{Value: "open"}, {Value: "attend"}}}
+	tests := []struct {
+		argType  ArgumentType
+		input    string
+		expected interface{}
+		invalid  bool
+	}{
+		{argType: IntArgument{}, input: " 42 ", expected: int64(42)},
+		{argType: IntArgument{}, input: "forty-two", invalid: true},
+		{argType: IntRange(1, 20), input: "20", expected: int64(20)},
+		{argType: IntRange(1, 20), input: "0", invalid: true},
+		{argType: DurationArgument{}, input: "2h30m", expected: time.Hour*2 + time.Minute*30},
+		{argType: DurationArgument{}, input: "-1h", invalid: true},
+		{argType: DateArgument{}, input: "2026-12-24", expected: time.Date(2026, 12, 24, 0, 0, 0, 0, time.UTC)},
+		{argType: DateArgument{}, input: "24.12.2026", invalid: true},
+		{argType: enum, input: "Attend", expected: "attend"},
+		{argType: enum, input: "maybe", invalid: true},
+		{argType: BoolArgument{}, input: "Yes", expected: true},
+		{argType: BoolArgument{}, input: "off", expected: false},
+		{argType: BoolArgument{}, input: "perhaps", invalid: true},
+		{argType: ScheduleArgument{}, input: "friday", invalid: true},
+	}
+	for _, test := range tests {
+		value, err := test.argType.Parse(nil, test.input)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("%T %q: expected an error, got %v", test.argType, test.input, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%T %q: %v", test.argType, test.input, err)
+			continue
+		}
+		if expectedTime, ok := test.expected.(time.Time); ok {
+			if !expectedTime.Equal(value.(time.Time)) {
+				t.Errorf("%T %q: expected %v, got %v", test.argType, test.input, test.expected, value)
+			}
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("%T %q: expected %v, got %v", test.argType, test.input, test.expected, value)
+		}
+	}
+
+	value, err := ScheduleArgument{}.Parse(nil, "friday 18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr := value.(*ScheduleExpression); expr.String() != "friday 18:00" {
+		t.Errorf("unexpected schedule %v", expr)
+	}
+}
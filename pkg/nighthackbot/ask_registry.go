@@ -0,0 +1,183 @@
+package nighthackbot
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errAskCanceled = errors.New("canceled")
+
+// askKey identifies the conversation of a user in a chat.
+type askKey struct {
+	ChatID int64
+	UserID int64
+}
+
+// pendingAsk is a question waiting for the answer of a single user.
+type pendingAsk struct {
+	key askKey
+	// messageIDs are the messages of the question, replies and buttons on them are matched against them
+	messageIDs []int
+	// userAnswer questions are answered by forwarding a message of the user or sharing their contact
+	userAnswer bool
+	callback   func(answer string, err error)
+}
+
+func (p *pendingAsk) hasMessage(messageID int) bool {
+	for _, id := range p.messageIDs {
+		if id == messageID {
+			return true
+		}
+	}
+	return false
+}
+
+// askRoute is the result of matching an incoming update against the pending questions.
+type askRoute struct {
+	// ask is the question the update answers or cancels, nil if there is none
+	ask    *pendingAsk
+	answer string
+	err    error
+	// handled means the update must not be processed as a command
+	handled bool
+	// callbackText is the notification shown for a button press which was handled
+	callbackText string
+}
+
+// askRegistry keeps the pending questions of all the conversations.
+// A question only accepts answers from the user who started the conversation,
+// so several users can answer their own questions in the same chat at the same time.
+type askRegistry struct {
+	mutex sync.Mutex
+	asks  []*pendingAsk
+}
+
+func newAskRegistry() *askRegistry {
+	return &askRegistry{}
+}
+
+func (r *askRegistry) add(ask *pendingAsk) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.asks = append(r.asks, ask)
+}
+
+// remove drops the question and reports whether it was still pending.
+func (r *askRegistry) remove(ask *pendingAsk) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.removeLocked(ask)
+}
+
+func (r *askRegistry) removeLocked(ask *pendingAsk) bool {
+	for i, a := range r.asks {
+		if a == ask {
+			r.asks = append(r.asks[:i], r.asks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// byMessage finds the question with the message in the chat.
+func (r *askRegistry) byMessage(chatID int64, messageID int) *pendingAsk {
+	for _, ask := range r.asks {
+		if ask.key.ChatID == chatID && ask.hasMessage(messageID) {
+			return ask
+		}
+	}
+	return nil
+}
+
+// byKey finds the latest question of the user in the chat.
+func (r *askRegistry) byKey(key askKey) *pendingAsk {
+	for i := len(r.asks) - 1; i >= 0; i-- {
+		if r.asks[i].key == key {
+			return r.asks[i]
+		}
+	}
+	return nil
+}
+
+// route matches the update against the pending questions. The matched question is removed from the registry,
+// the caller is responsible for calling its callback.
+func (r *askRegistry) route(update tgbotapi.Update) askRoute {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if query := update.CallbackQuery; query != nil && query.Message != nil && query.From != nil {
+		var answer string
+		var err error
+		switch {
+		case query.Data == "/cancel":
+			err = errAskCanceled
+		case query.Data == "/yes":
+		case strings.HasPrefix(query.Data, "/sugg "):
+			answer = strings.TrimPrefix(query.Data, "/sugg ")
+		default:
+			return askRoute{}
+		}
+		key := askKey{query.Message.Chat.ID, query.From.ID}
+		ask := r.byMessage(key.ChatID, query.Message.MessageID)
+		if ask == nil {
+			// buttons of questions sent before a restart
+			ask = r.byKey(key)
+		}
+		if ask == nil {
+			// the question has already been answered or timed out
+			return askRoute{handled: true}
+		}
+		if ask.key != key {
+			return askRoute{handled: true, callbackText: "This question is for someone else"}
+		}
+		r.removeLocked(ask)
+		route := askRoute{ask: ask, answer: answer, err: err, handled: true}
+		switch {
+		case err != nil:
+			route.callbackText = "Canceled"
+		case query.Data == "/yes":
+			route.callbackText = "Confirmed"
+		}
+		return route
+	}
+
+	if msg := update.Message; msg != nil && msg.From != nil {
+		key := askKey{msg.Chat.ID, msg.From.ID}
+		var ask *pendingAsk
+		if msg.ReplyToMessage != nil {
+			ask = r.byMessage(key.ChatID, msg.ReplyToMessage.MessageID)
+			if ask != nil && ask.key != key && !strings.HasPrefix(msg.Text, "/") {
+				// someone else replied to the question, ignore it
+				return askRoute{handled: true}
+			}
+		}
+		if ask == nil || ask.key != key {
+			ask = r.byKey(key)
+		}
+		if ask == nil {
+			return askRoute{}
+		}
+		r.removeLocked(ask)
+		if strings.HasPrefix(msg.Text, "/") {
+			// a new command cancels the question and is executed
+			return askRoute{ask: ask, err: errAskCanceled}
+		}
+		answer := msg.Text
+		switch {
+		case msg.Document != nil:
+			// documents are answered with their file ID, which can be downloaded with GetFileDirectURL
+			answer = msg.Document.FileID
+		case ask.userAnswer && msg.Contact != nil && msg.Contact.UserID != 0:
+			// shared contacts and forwarded messages are answered with the ID of the user, see UserArgument
+			answer = strconv.FormatInt(msg.Contact.UserID, 10)
+		case ask.userAnswer && msg.ForwardFrom != nil:
+			answer = strconv.FormatInt(msg.ForwardFrom.ID, 10)
+		}
+		return askRoute{ask: ask, answer: answer, handled: true}
+	}
+	return askRoute{}
+}
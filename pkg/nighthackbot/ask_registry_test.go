@@ -0,0 +1,182 @@
+package nighthackbot
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testGroupChatID = -1001
+
+// recordingAsk registers a question and records what its callback receives.
+type recordingAsk struct {
+	*pendingAsk
+	answers []string
+	errs    []error
+}
+
+func addRecordingAsk(r *askRegistry, userID int64, messageIDs ...int) *recordingAsk {
+	ask := &recordingAsk{}
+	ask.pendingAsk = &pendingAsk{
+		key:        askKey{testGroupChatID, userID},
+		messageIDs: messageIDs,
+		callback: func(answer string, err error) {
+			ask.answers = append(ask.answers, answer)
+			ask.errs = append(ask.errs, err)
+		},
+	}
+	r.add(ask.pendingAsk)
+	return ask
+}
+
+// deliver routes the update and calls the callback like AskService does.
+func deliver(r *askRegistry, update tgbotapi.Update) askRoute {
+	route := r.route(update)
+	if route.ask != nil {
+		route.ask.callback(route.answer, route.err)
+	}
+	return route
+}
+
+func textUpdate(userID int64, text string, replyTo int) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		Chat: &tgbotapi.Chat{ID: testGroupChatID},
+		From: &tgbotapi.User{ID: userID},
+		Text: text,
+	}
+	if replyTo != 0 {
+		msg.ReplyToMessage = &tgbotapi.Message{MessageID: replyTo}
+	}
+	return tgbotapi.Update{Message: msg}
+}
+
+func buttonUpdate(userID int64, data string, messageID int) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "query",
+		From:    &tgbotapi.User{ID: userID},
+		Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: testGroupChatID}},
+		Data:    data,
+	}}
+}
+
+func TestAskRegistryInterleavedUsers(t *testing.T) {
+	r := newAskRegistry()
+	alice := addRecordingAsk(r, 1, 100)
+	bob := addRecordingAsk(r, 2, 200)
+
+	// bob answers first, then a bystander replies to alice's question, then alice answers
+	if route := deliver(r, textUpdate(2, "bob's answer", 200)); !route.handled {
+		t.Fatal("expected bob's answer to be handled")
+	}
+	if route := deliver(r, textUpdate(3, "not mine", 100)); !route.handled || route.ask != nil {
+		t.Fatalf("expected the bystander's reply to be ignored, got %+v", route)
+	}
+	if route := deliver(r, textUpdate(1, "alice's answer", 0)); !route.handled {
+		t.Fatal("expected alice's answer to be handled")
+	}
+
+	if len(alice.answers) != 1 || alice.answers[0] != "alice's answer" {
+		t.Errorf("unexpected answers of alice: %q", alice.answers)
+	}
+	if len(bob.answers) != 1 || bob.answers[0] != "bob's answer" {
+		t.Errorf("unexpected answers of bob: %q", bob.answers)
+	}
+	if len(r.asks) != 0 {
+		t.Errorf("expected no pending questions, got %d", len(r.asks))
+	}
+}
+
+func TestAskRegistryButtons(t *testing.T) {
+	r := newAskRegistry()
+	alice := addRecordingAsk(r, 1, 100, 101)
+	bob := addRecordingAsk(r, 2, 200)
+
+	// bob cannot press alice's suggestion
+	route := deliver(r, buttonUpdate(2, "/sugg open", 101))
+	if !route.handled || route.ask != nil || route.callbackText == "" {
+		t.Fatalf("expected bob's press to be refused, got %+v", route)
+	}
+	deliver(r, buttonUpdate(1, "/sugg open", 101))
+	deliver(r, buttonUpdate(2, "/cancel", 200))
+
+	if len(alice.answers) != 1 || alice.answers[0] != "open" || alice.errs[0] != nil {
+		t.Errorf("unexpected answers of alice: %q %v", alice.answers, alice.errs)
+	}
+	if len(bob.errs) != 1 || bob.errs[0] != errAskCanceled {
+		t.Errorf("expected bob's question to be canceled, got %v", bob.errs)
+	}
+
+	// pressing the button of an answered question does nothing
+	if route := deliver(r, buttonUpdate(1, "/yes", 100)); !route.handled || route.ask != nil {
+		t.Errorf("expected stale button to be ignored, got %+v", route)
+	}
+}
+
+func TestAskRegistryCommandCancelsOwnQuestion(t *testing.T) {
+	r := newAskRegistry()
+	alice := addRecordingAsk(r, 1, 100)
+	bob := addRecordingAsk(r, 2, 200)
+
+	// a second /admin from bob cancels only bob's question and is executed as a command
+	route := deliver(r, textUpdate(2, "/admin", 0))
+	if route.handled {
+		t.Error("expected the command to be executed")
+	}
+	if len(bob.errs) != 1 || bob.errs[0] != errAskCanceled {
+		t.Errorf("expected bob's question to be canceled, got %v", bob.errs)
+	}
+	if len(alice.answers) != 0 {
+		t.Errorf("expected alice's question to stay pending, got %q", alice.answers)
+	}
+
+	// messages of users without questions are not answers
+	if route := deliver(r, textUpdate(3, "hello", 0)); route.handled || route.ask != nil {
+		t.Errorf("expected message to pass through, got %+v", route)
+	}
+	deliver(r, textUpdate(1, "still here", 100))
+	if len(alice.answers) != 1 || alice.answers[0] != "still here" {
+		t.Errorf("unexpected answers of alice: %q", alice.answers)
+	}
+}
+
+func TestAskRegistryReplyPicksQuestion(t *testing.T) {
+	r := newAskRegistry()
+	first := addRecordingAsk(r, 1, 100)
+	second := addRecordingAsk(r, 1, 200)
+
+	// replies go to the question they reply to, other messages to the latest one
+	deliver(r, textUpdate(1, "for the first", 100))
+	deliver(r, textUpdate(1, "for the second", 0))
+	if len(first.answers) != 1 || first.answers[0] != "for the first" {
+		t.Errorf("unexpected answers of the first question: %q", first.answers)
+	}
+	if len(second.answers) != 1 || second.answers[0] != "for the second" {
+		t.Errorf("unexpected answers of the second question: %q", second.answers)
+	}
+}
+
+func TestAskRegistryUserAnswers(t *testing.T) {
+	r := newAskRegistry()
+	ask := addRecordingAsk(r, 1, 10)
+	ask.userAnswer = true
+
+	forwarded := textUpdate(1, "hello from bob", 0)
+	forwarded.Message.ForwardFrom = &tgbotapi.User{ID: 42}
+	deliver(r, forwarded)
+
+	r.add(ask.pendingAsk)
+	contact := textUpdate(1, "", 0)
+	contact.Message.Contact = &tgbotapi.Contact{UserID: 43, FirstName: "Carol"}
+	deliver(r, contact)
+
+	if len(ask.answers) != 2 || ask.answers[0] != "42" || ask.answers[1] != "43" {
+		t.Errorf("expected the user IDs as answers, got %q", ask.answers)
+	}
+
+	// other questions get the text of a forwarded message
+	text := addRecordingAsk(r, 1, 11)
+	deliver(r, forwarded)
+	if len(text.answers) != 1 || text.answers[0] != "hello from bob" {
+		t.Errorf("expected the text of the forwarded message as the answer, got %q", text.answers)
+	}
+}
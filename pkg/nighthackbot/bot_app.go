@@ -2,10 +2,14 @@ package nighthackbot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/rs/zerolog"
@@ -16,18 +20,42 @@ import (
 	"gorm.io/gorm"
 )
 
+// commandRateBurst commands can be executed at once by a user, who gets another one every commandRateInterval
+const (
+	commandRateBurst    = 10
+	commandRateInterval = 6 * time.Second
+)
+
+// shutdownTimeout is how long the commands which are still running get to finish when the bot stops
+const shutdownTimeout = 30 * time.Second
+
 type BotApp struct {
-	Config  *Config
-	Bot     *tgbotapi.BotAPI
-	BotName string
-	DB      *gorm.DB
+	Config *Config
+	Bot    *tgbotapi.BotAPI
+	// Messenger sends the messages, all the services and commands use it instead of Bot
+	Messenger Messenger
+	BotName   string
+	DB        *gorm.DB
 
 	// services
-	AskService   *AskService
-	UsersService *UsersService
+	AskService        *AskService
+	UsersService      *UsersService
+	SettingsService   *SettingsService
+	SchedulerService  *SchedulerService
+	VolunteerService  *VolunteerService
+	HTTPService       *HTTPService
+	AuditService      *AuditService
+	KeysService       *KeysService
+	AttendanceService *AttendanceService
+	WebhookService    *WebhookService
 
 	// commands
 	Commands []Command
+	// Middleware wraps the execution of every command, the first one is the outermost
+	Middleware []Middleware
+
+	// UpdateQueue processes the incoming updates, it is created by Start
+	UpdateQueue *UpdateQueue
 }
 
 func NewBotApp() (a *BotApp) {
@@ -36,9 +64,29 @@ func NewBotApp() (a *BotApp) {
 	}
 	a.AskService = NewAskService(a)
 	a.UsersService = NewUsersService(a)
+	a.SettingsService = NewSettingsService(a)
+	a.SchedulerService = NewSchedulerService(a)
+	a.VolunteerService = NewVolunteerService(a)
+	a.HTTPService = NewHTTPService(a)
+	a.AuditService = NewAuditService(a)
+	a.KeysService = NewKeysService(a)
+	a.AttendanceService = NewAttendanceService(a)
+	a.WebhookService = NewWebhookService(a)
 	a.Commands = []Command{
 		&AdminCommand{App: a},
 		&StartCommand{App: a},
+		&VolunteerCommand{App: a},
+		&ScheduleCommand{App: a},
+		&ICalCommand{App: a},
+		&KeysCommand{App: a},
+		&CheckInCommand{App: a},
+		&CheckOutCommand{App: a},
+	}
+	a.Middleware = []Middleware{
+		LoggingMiddleware(),
+		RecoverMiddleware(),
+		RateLimitMiddleware(NewRateLimiter(commandRateBurst, commandRateInterval)),
+		PermissionMiddleware(a),
 	}
 	return
 }
@@ -52,19 +100,71 @@ func (app *BotApp) Run() {
 		log.Fatal().Msgf("Failed to load config: %s", err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := app.Start(ctx); err != nil {
+		log.Fatal().Msgf("%s", err)
+	}
+	log.Info().Msgf("Stopped")
+}
+
+// Start runs the bot with the already loaded config until the context is done.
+// It then stops receiving updates, processes the ones already received, waits up to shutdownTimeout for the running commands,
+// stops the scheduler and the HTTP server and closes the DB.
+func (app *BotApp) Start(ctx context.Context) error {
 	// init db
 	if err := app.InitDB(); err != nil {
-		log.Fatal().Msgf("Failed to init db: %s", err)
+		return fmt.Errorf("failed to init db: %w", err)
+	}
+	defer app.closeDB()
+	if err := app.UsersService.BootstrapAdmins(); err != nil {
+		return fmt.Errorf("failed to bootstrap admins: %w", err)
 	}
 
 	// init telegram
 	if err := app.InitTelegram(); err != nil {
-		log.Fatal().Msgf("Failed to init telegram: %s", err)
+		return fmt.Errorf("failed to init telegram: %w", err)
+	}
+
+	// start scheduler
+	if err := app.SchedulerService.Start(); err != nil {
+		return fmt.Errorf("failed to start scheduler: %w", err)
+	}
+	defer app.SchedulerService.Stop()
+
+	// start http server
+	app.HTTPService.Start()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		app.HTTPService.Stop(shutdownCtx)
+	}()
+
+	app.UpdateQueue = NewUpdateQueue(app.Config.Updates.Workers, app.Config.Updates.QueueSize, app.processUpdate)
+
+	// resume the conversations interrupted by a restart
+	if err := app.AskService.ResumePendingQuestions(ctx); err != nil {
+		log.Error().Msgf("Failed to resume pending questions: %s", err)
 	}
 
 	// run loop
-	if err := app.RunLoop(); err != nil {
-		log.Fatal().Msgf("Failed to run loop: %s", err)
+	err := app.RunLoop(ctx)
+	if !app.UpdateQueue.Wait(shutdownTimeout) {
+		log.Warn().Dur("timeout", shutdownTimeout).Msgf("Gave up waiting for running commands")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run loop: %w", err)
+	}
+	return nil
+}
+
+func (app *BotApp) closeDB() {
+	sqlDB, err := app.DB.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to close DB")
 	}
 }
 
@@ -87,12 +187,17 @@ func (app *BotApp) LoadConfig() error {
 }
 
 func (app *BotApp) InitTelegram() error {
-	bot, err := tgbotapi.NewBotAPI(app.Config.Telegram.Token)
+	endpoint := app.Config.Telegram.APIEndpoint
+	if endpoint == "" {
+		endpoint = tgbotapi.APIEndpoint
+	}
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(app.Config.Telegram.Token, endpoint)
 	if err != nil {
 		return fmt.Errorf("error creating bot: %s", err)
 	}
 	bot.Debug = app.Config.Telegram.Debug
 	app.Bot = bot
+	app.Messenger = NewTelegramMessenger(bot)
 	me, err := app.Bot.GetMe()
 	if err != nil {
 		return fmt.Errorf("error getting bot info: %s", err)
@@ -126,7 +231,7 @@ func (app *BotApp) InitDB() error {
 	}
 	app.DB = db
 
-	if err := app.DB.AutoMigrate(&User{}); err != nil {
+	if err := app.DB.AutoMigrate(&User{}, &ConfigEntry{}, &Nighthack{}, &Volunteer{}, &DecisionRecord{}, &ScheduleException{}, &PendingQuestion{}, &AuditLogEntry{}, &Key{}, &Attendance{}); err != nil {
 		return fmt.Errorf("error auto-migrating db: %s", err)
 	}
 
@@ -135,7 +240,8 @@ func (app *BotApp) InitDB() error {
 	return nil
 }
 
-func (app *BotApp) RunLoop() error {
+// RunLoop processes the incoming updates until the context is done.
+func (app *BotApp) RunLoop(ctx context.Context) error {
 	myCommands := []tgbotapi.BotCommand{}
 	for _, cmd := range app.Commands {
 		rawCmd := strings.TrimPrefix(strings.Split(cmd.Aliases()[0], " ")[0], "/")
@@ -145,90 +251,169 @@ func (app *BotApp) RunLoop() error {
 		})
 	}
 
-	commandsConfig := tgbotapi.NewSetMyCommands(myCommands...)
-
-	if _, err := app.Bot.Request(commandsConfig); err != nil {
+	if err := app.Messenger.SetCommands(myCommands); err != nil {
 		return fmt.Errorf("failed to set my commands: %v", err)
 	}
+	updates, err := app.updatesChannel()
+	if err != nil {
+		return err
+	}
 	log.Info().Msgf("Receiving messages...")
-	u := tgbotapi.NewUpdate(0)
-	u.AllowedUpdates = []string{"message", "inline_query", "callback_query", "edited_message"}
-	u.Timeout = 60
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				return nil
+			}
+			app.dispatchUpdate(ctx, u, nil)
+		case <-ctx.Done():
+			log.Info().Msgf("Stopping, no longer receiving messages")
+			app.stopReceiving()
+			app.drainUpdates(ctx, updates)
+			return nil
+		}
+	}
+}
+
+// stopReceiving stops the webhook server or the long polling.
+func (app *BotApp) stopReceiving() {
+	if app.WebhookService.Enabled() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		app.WebhookService.Stop(shutdownCtx)
+		return
+	}
+	app.Bot.StopReceivingUpdates()
+}
 
-	updates := app.Bot.GetUpdatesChan(u)
-	for u := range updates {
-		go func(update tgbotapi.Update) {
-			log.Printf("incoming message: %+v", update)
-			if app.AskService.ProcessIncomingMessage(update) {
+// drainUpdates queues the updates which were received but not dispatched yet, Telegram does not send them again.
+// They are processed with the done context, so their commands do not wait for answers.
+func (app *BotApp) drainUpdates(ctx context.Context, updates tgbotapi.UpdatesChannel) {
+	waitCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
 				return
 			}
-			var err error
-			var cmdText string
-
-			args := &CommandArguments{
-				BotApp:         app,
-				update:         &update,
-				namedArguments: map[string]string{},
+			if err := app.UpdateQueue.enqueue(waitCtx, ctx, u, nil); err != nil {
+				log.Warn().Err(err).Int("update_id", u.UpdateID).Msgf("Dropped update")
 			}
-			if update.Message != nil {
-				cmdText = update.Message.Text
-				args.ChatID = update.Message.Chat.ID
-				args.FromUserID = update.Message.From.ID
-				args.FromUserName = update.Message.From.UserName
+		default:
+			return
+		}
+	}
+}
+
+// dispatchUpdate queues the update to be processed after the earlier updates of its chat.
+func (app *BotApp) dispatchUpdate(ctx context.Context, update tgbotapi.Update, replay []string) {
+	if err := app.UpdateQueue.Enqueue(ctx, update, replay); err != nil {
+		log.Warn().Err(err).Int("update_id", update.UpdateID).Msgf("Dropped update")
+	}
+}
+
+// updatesChannel returns the updates pushed to the webhook if it is configured, otherwise it starts long polling.
+func (app *BotApp) updatesChannel() (tgbotapi.UpdatesChannel, error) {
+	if app.WebhookService.Enabled() {
+		return app.WebhookService.Start()
+	}
+	// long polling does not work while a webhook is set
+	if _, err := app.Bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	u := tgbotapi.NewUpdate(0)
+	u.AllowedUpdates = allowedUpdates
+	u.Timeout = 60
+	return app.Bot.GetUpdatesChan(u), nil
+}
+
+// ProcessUpdate answers a pending question or executes the command of an incoming update.
+// The context is passed to the command and cancels its questions.
+func (app *BotApp) ProcessUpdate(ctx context.Context, update tgbotapi.Update) {
+	app.processUpdate(ctx, update, nil)
+}
+
+// processUpdate executes the command of the update, returning the replayed answers to its questions first.
+func (app *BotApp) processUpdate(ctx context.Context, update tgbotapi.Update, replay []string) {
+	log.Printf("incoming message: %+v", update)
+	if replay == nil && app.AskService.ProcessIncomingMessage(update) {
+		return
+	}
+	var err error
+	var cmdText string
+
+	args := &CommandArguments{
+		BotApp:         app,
+		update:         &update,
+		namedArguments: map[string]string{},
+	}
+	if update.Message != nil {
+		cmdText = update.Message.Text
+		args.ChatID = update.Message.Chat.ID
+		args.ChatType = update.Message.Chat.Type
+		args.FromUserID = update.Message.From.ID
+		args.FromUserName = update.Message.From.UserName
+	}
+	if update.CallbackQuery != nil {
+		cmdText = update.CallbackQuery.Data
+		args.ChatID = update.CallbackQuery.Message.Chat.ID
+		args.ChatType = update.CallbackQuery.Message.Chat.Type
+		args.FromUserID = update.CallbackQuery.From.ID
+		args.FromUserName = update.CallbackQuery.From.UserName
+	}
+	didFind := false
+	for _, cmd := range app.Commands {
+
+		if CommandMatches(app, cmd, cmdText) {
+			didFind = true
+			args.Command = cmd
+			tokens, tokenizeError := TokenizeCommand(cmdText)
+			if tokenizeError != nil {
+				err = tokenizeError
+				break
 			}
-			if update.CallbackQuery != nil {
-				cmdText = update.CallbackQuery.Data
-				args.ChatID = update.CallbackQuery.Message.Chat.ID
-				args.FromUserID = update.CallbackQuery.From.ID
-				args.FromUserName = update.CallbackQuery.From.UserName
+			args.CommandName = tokens[0].Value
+			if assignError := args.assignArguments(cmdText, tokens[1:]); assignError != nil {
+				err = assignError
+				break
 			}
-			seg := strings.Split(cmdText, " ")
-			args.CommandName = seg[0]
-			args.Arguments = seg[1:]
-			didFind := false
-			for _, cmd := range app.Commands {
-
-				if CommandMatches(app, cmd, cmdText) {
-					args.Command = cmd
-					for i, argTpl := range cmd.Arguments() {
-						if argTpl.Variadic {
-							args.namedArguments[argTpl.Name] = strings.Join(args.Arguments[i:], " ")
-							break
-						}
-						if i >= len(args.Arguments) {
-							break
-						}
-						args.namedArguments[argTpl.Name] = args.Arguments[i]
-					}
-					if usersError := app.UsersService.AddUserToArgs(args); usersError != nil {
-						err = usersError
-						break
-					}
-					ctx := context.TODO()
-					err = cmd.Execute(ctx, args)
-					didFind = true
-					break
-				}
+			if usersError := app.UsersService.AddUserToArgs(args); usersError != nil {
+				err = usersError
+				break
 			}
-			if !didFind && update.CallbackQuery != nil {
-				app.Bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+			messageID := 0
+			if update.Message != nil {
+				messageID = update.Message.MessageID
 			}
-			if err != nil {
-				log.Printf("Error while processing command %v: %v", cmdText, err)
-				if args.update.CallbackQuery != nil {
-					msg := tgbotapi.NewCallback(args.update.CallbackQuery.ID, "🚫 Error:"+err.Error())
-					app.Bot.Send(msg)
-				} else {
-
-					msg := tgbotapi.NewMessage(args.ChatID, "🚫 Error: <b>"+html.EscapeString(err.Error())+"</b>")
-					msg.ParseMode = "HTML"
-					if update.Message != nil {
-						msg.ReplyToMessageID = update.Message.MessageID
-					}
-					app.Bot.Send(msg)
-				}
+			conversation := app.AskService.BeginConversation(ctx, args, cmdText, messageID, replay)
+			err = chainMiddleware(executeCommand, app.Middleware...)(ctx, args)
+			app.AskService.EndConversation(conversation)
+			break
+		}
+	}
+	if replay != nil {
+		// the resumed command failed before its conversation began
+		app.AskService.discardResumed(askKey{args.ChatID, args.FromUserID})
+	}
+	if !didFind {
+		args.AnswerCallback("")
+	}
+	if err != nil && ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// interrupted by the shutdown, a pending question is asked again after the restart
+		log.Info().Str("command", cmdText).Msgf("Command interrupted by shutdown")
+		return
+	}
+	if err != nil {
+		log.Printf("Error while processing command %v: %v", cmdText, err)
+		if answered, _ := args.AnswerCallback("🚫 Error:" + err.Error()); !answered {
+
+			msg := tgbotapi.NewMessage(args.ChatID, "🚫 Error: <b>"+html.EscapeString(err.Error())+"</b>")
+			msg.ParseMode = "HTML"
+			if update.Message != nil {
+				msg.ReplyToMessageID = update.Message.MessageID
 			}
-		}(u)
+			app.Messenger.Send(msg)
+		}
 	}
-	return nil
 }
@@ -0,0 +1,163 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestBotApp returns an app with a migrated sqlite database in a temporary directory.
+func newTestBotApp(t *testing.T) *BotApp {
+	t.Helper()
+	app := NewBotApp()
+	app.Config.DB.Type = "sqlite"
+	app.Config.DB.Filename = filepath.Join(t.TempDir(), "test.db")
+	if err := app.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// newFakeBotApp returns a test app which sends its messages to a FakeMessenger.
+func newFakeBotApp(t *testing.T) (*BotApp, *FakeMessenger) {
+	t.Helper()
+	app := newTestBotApp(t)
+	messenger := NewFakeMessenger()
+	app.Messenger = messenger
+	app.BotName = "testbot"
+	return app, messenger
+}
+
+// fakeChat is a chat in which scripted updates are sent to the bot.
+type fakeChat struct {
+	app           *BotApp
+	chat          tgbotapi.Chat
+	nextMessageID int
+}
+
+func newFakeChat(app *BotApp, chatID int64, chatType string) *fakeChat {
+	return &fakeChat{app: app, chat: tgbotapi.Chat{ID: chatID, Type: chatType}, nextMessageID: 1}
+}
+
+func (c *fakeChat) message(from *User, text string) *tgbotapi.Message {
+	c.nextMessageID++
+	return &tgbotapi.Message{
+		MessageID: c.nextMessageID,
+		Chat:      &c.chat,
+		From:      &tgbotapi.User{ID: from.TelegramID, UserName: from.Username},
+		Text:      text,
+	}
+}
+
+// send processes a message from the user and waits until the bot has handled it.
+func (c *fakeChat) send(from *User, text string) *tgbotapi.Message {
+	msg := c.message(from, text)
+	c.app.ProcessUpdate(context.Background(), tgbotapi.Update{Message: msg})
+	return msg
+}
+
+// start processes a message from the user in the background, for commands which ask questions.
+func (c *fakeChat) start(from *User, text string) (*tgbotapi.Message, chan struct{}) {
+	msg := c.message(from, text)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.app.ProcessUpdate(context.Background(), tgbotapi.Update{Message: msg})
+	}()
+	return msg, done
+}
+
+// reply processes a reply of the user to a message of the bot.
+func (c *fakeChat) reply(from *User, to FakeMessage, text string) {
+	msg := c.message(from, text)
+	msg.ReplyToMessage = &tgbotapi.Message{MessageID: to.MessageID, Chat: &c.chat}
+	c.app.ProcessUpdate(context.Background(), tgbotapi.Update{Message: msg})
+}
+
+// press processes a press of a button of a message of the bot.
+func (c *fakeChat) press(from *User, on FakeMessage, data string) {
+	c.app.ProcessUpdate(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      fmt.Sprintf("callback-%d", on.MessageID),
+		From:    &tgbotapi.User{ID: from.TelegramID, UserName: from.Username},
+		Message: &tgbotapi.Message{MessageID: on.MessageID, Chat: &c.chat},
+		Data:    data,
+	}})
+}
+
+func waitForDone(t *testing.T, done chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the command to finish")
+	}
+}
+
+func saveTestUser(t *testing.T, app *BotApp, user *User) *User {
+	t.Helper()
+	if err := app.DB.Save(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// sentText is a matcher for FakeMessenger.WaitFor.
+func sentText(chatID int64, substring string) func(msg FakeMessage) bool {
+	return func(msg FakeMessage) bool {
+		return msg.Kind == FakeMessageSent && msg.ChatID == chatID && strings.Contains(msg.Text, substring)
+	}
+}
+
+func updateWithMessage(msg *tgbotapi.Message) tgbotapi.Update {
+	return tgbotapi.Update{Message: msg}
+}
+
+// waitForQuestion waits until the bot waits for the answer of the user to the question message.
+// The message is sent before the question is registered, so answering right after it is sent would be too early.
+func waitForQuestion(t *testing.T, app *BotApp, userID int64, question FakeMessage) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		registry := app.AskService.registry
+		registry.mutex.Lock()
+		ask := registry.byMessage(question.ChatID, question.MessageID)
+		registry.mutex.Unlock()
+		if ask != nil && ask.key.UserID == userID {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for the question %q", question.Text)
+}
+
+func TestDrainUpdatesProcessesReceivedUpdates(t *testing.T) {
+	app := NewBotApp()
+	var mutex sync.Mutex
+	processed := []int{}
+	// a queue smaller than the received updates, the drain waits for places
+	app.UpdateQueue = NewUpdateQueue(1, 1, func(ctx context.Context, update tgbotapi.Update, replay []string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		processed = append(processed, update.Message.MessageID)
+	})
+	updates := make(chan tgbotapi.Update, 5)
+	for i := 1; i <= 5; i++ {
+		updates <- queueTestUpdate(1, i)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	app.drainUpdates(ctx, updates)
+	if !app.UpdateQueue.Wait(5 * time.Second) {
+		t.Fatal("the drained updates were not processed")
+	}
+	if len(processed) != 5 {
+		t.Errorf("expected all received updates to be processed, got %v", processed)
+	}
+}
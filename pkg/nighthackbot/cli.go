@@ -0,0 +1,42 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const cliUsage = `usage:
+  nighthackbot                                 runs the bot
+  nighthackbot admin grant|revoke <telegram-id>  grants or revokes the admin role of a user`
+
+// RunCLI executes a maintenance subcommand given on the command line instead of running the bot.
+func (app *BotApp) RunCLI(args []string) error {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if err := app.LoadConfig(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := app.InitDB(); err != nil {
+		return fmt.Errorf("failed to init db: %w", err)
+	}
+	return app.runCLI(args)
+}
+
+func (app *BotApp) runCLI(args []string) error {
+	if len(args) == 3 && args[0] == "admin" && (args[1] == "grant" || args[1] == "revoke") {
+		telegramID, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid telegram ID %q", args[2])
+		}
+		user, err := app.UsersService.SetAdmin(telegramID, args[1] == "grant", nil, "cli")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v admin: %v\n", user.DisplayName(), user.IsAdmin)
+		return nil
+	}
+	return fmt.Errorf("unknown command\n%v", cliUsage)
+}
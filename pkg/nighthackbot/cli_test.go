@@ -0,0 +1,42 @@
+package nighthackbot
+
+import (
+	"testing"
+)
+
+func TestRunCLIAdmin(t *testing.T) {
+	app := newTestBotApp(t)
+
+	if err := app.runCLI([]string{"admin", "grant", "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	user := &User{}
+	if err := app.DB.Where("telegram_id = ?", 1234).First(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsAdmin {
+		t.Errorf("expected the user to be an admin")
+	}
+
+	if err := app.runCLI([]string{"admin", "revoke", "1234"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.DB.First(user, "telegram_id = ?", 1234).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.IsAdmin {
+		t.Errorf("expected the admin role to be revoked")
+	}
+	var entries int64
+	app.DB.Model(&AuditLogEntry{}).Count(&entries)
+	if entries != 2 {
+		t.Errorf("expected 2 audit log entries, got %d", entries)
+	}
+
+	if err := app.runCLI([]string{"admin", "grant", "abc"}); err == nil {
+		t.Errorf("expected an error for an invalid ID")
+	}
+	if err := app.runCLI([]string{"frobnicate"}); err == nil {
+		t.Errorf("expected an error for an unknown command")
+	}
+}
@@ -1,10 +1,16 @@
 package nighthackbot
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"html"
+	"io"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gorm.io/gorm"
@@ -24,20 +30,41 @@ func (s *AdminCommand) Arguments() []*CommandDefArgument {
 	}}
 }
 
+func (s *AdminCommand) Requirements() CommandRequirements {
+	return CommandRequirements{Role: RoleAdmin}
+}
+
 func (f *AdminCommand) Help() string {
 	return "shows a menu for admin commands"
 }
 
-func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) error {
-	subcommands := map[string]func(ctx context.Context, args *CommandArguments) error{
+// ReplaySafe reports whether the subcommand can be resumed after a restart,
+// all of them ask their questions before changing anything.
+func (f *AdminCommand) ReplaySafe(args *CommandArguments) bool {
+	_, ok := f.subcommands()[args.namedArguments["command"]]
+	return ok
+}
+
+func (f *AdminCommand) subcommands() map[string]func(ctx context.Context, args *CommandArguments) error {
+	return map[string]func(ctx context.Context, args *CommandArguments) error{
 		"add_admin_user":                f.addAdminUser,
 		"remove_admin_user":             f.removeAdminUser,
-		"set_call_for_volounteers_time": nil,
-		"set_nighthack_time":            nil,
-		"force_next_nighthack":          nil,
-		"cancel_next_nighthack":         nil,
-		"override_next_nighthack_time":  nil,
+		"set_user_role":                 f.setUserRole,
+		"add_key":                       f.addKey,
+		"assign_key":                    f.assignKey,
+		"return_key":                    f.returnKey,
+		"settings":                      f.settings,
+		"set_announcement_chat":         f.setAnnouncementChat,
+		"set_call_for_volounteers_time": f.setCallForVolunteersTime,
+		"set_nighthack_time":            f.setNighthackTime,
+		"force_next_nighthack":          f.forceNextNighthack,
+		"cancel_next_nighthack":         f.cancelNextNighthack,
+		"override_next_nighthack_time":  f.overrideNextNighthackTime,
+		"import_ical":                   f.importICal,
 	}
+}
+
+func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) error {
 	if args.namedArguments["command"] == "" {
 		admins := []User{}
 		if err := f.App.DB.Where("is_admin = ?", true).Find(&admins).Error; err != nil {
@@ -48,11 +75,7 @@ func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) erro
 			if adminsStr != "" {
 				adminsStr += ", "
 			}
-			if admin.Username != "" {
-				adminsStr += "@" + admin.Username
-			} else {
-				adminsStr += strconv.FormatInt(admin.TelegramID, 10)
-			}
+			adminsStr += admin.DisplayName()
 		}
 		msg := tgbotapi.NewMessage(args.update.Message.Chat.ID, fmt.Sprintf("Current admins: %v\n\nAdmin options:", adminsStr))
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
@@ -63,11 +86,29 @@ func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) erro
 				tgbotapi.NewInlineKeyboardButtonData("👤 Add admin user", "/admin add_admin_user"),
 				tgbotapi.NewInlineKeyboardButtonData("❌ Remove admin user", "/admin remove_admin_user"),
 			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🙋 Set user role", "/admin set_user_role"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("--- 🔑 Keys ---", "null"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("➕ Add key", "/admin add_key"),
+				tgbotapi.NewInlineKeyboardButtonData("🤝 Assign key", "/admin assign_key"),
+				tgbotapi.NewInlineKeyboardButtonData("↩️ Return key", "/admin return_key"),
+			),
 
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData("➡️⏰ Set call for volounteers time", "/admin set_call_for_volounteers_time"),
 				tgbotapi.NewInlineKeyboardButtonData("➡️🕑 Set nighthack time", "/admin set_nighthack_time"),
 			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("⚙️ Settings", "/admin settings"),
+				tgbotapi.NewInlineKeyboardButtonData("📢 Announce in this chat", "/admin set_announcement_chat"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("📥 Import calendar", "/admin import_ical"),
+			),
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData("--- 🎟️ Next nighthack ---", "null"),
 			),
@@ -79,15 +120,15 @@ func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) erro
 				tgbotapi.NewInlineKeyboardButtonData("🕑 Override next nighthack time", "/admin override_next_nighthack_time"),
 			),
 		)
-		_, err := f.App.Bot.Send(
+		_, err := f.App.Messenger.Send(
 			msg,
 		)
 		return err
 	}
 
-	if subcommand, ok := subcommands[args.namedArguments["command"]]; ok && subcommand != nil {
+	if subcommand, ok := f.subcommands()[args.namedArguments["command"]]; ok && subcommand != nil {
 		if args.update.CallbackQuery != nil {
-			f.App.Bot.Send(tgbotapi.NewCallback(args.update.CallbackQuery.ID, ""))
+			args.AnswerCallback("")
 			args.update.CallbackQuery = nil
 		}
 		return subcommand(ctx, args)
@@ -97,69 +138,502 @@ func (f *AdminCommand) Execute(ctx context.Context, args *CommandArguments) erro
 }
 
 func (f *AdminCommand) addAdminUser(ctx context.Context, args *CommandArguments) error {
-	result, err := f.App.AskService.AskForArgument(args.ChatID, "Enter telegram <b>USER ID</b> for the new admin:\nTip: you can use https://t.me/username_to_id_bot")
+	target, err := f.targetUser(args, "Who should be the new admin? Forward a message of the user, share their contact or enter their @username or telegram <b>USER ID</b>:\nTip: you can use https://t.me/username_to_id_bot")
+	if err != nil {
+		return err
+	}
+	if target.IsAdmin {
+		return fmt.Errorf("%v is already an admin", target.DisplayName())
+	}
+	target, err = f.App.UsersService.SetAdmin(target.TelegramID, true, args.User, "/admin")
 	if err != nil {
 		return err
 	}
 
-	parsedUserID, err := strconv.ParseInt(result, 10, 64)
+	text := fmt.Sprintf("%v (<b>%d</b>) is now an admin", html.EscapeString(target.DisplayName()), target.TelegramID)
+	notification := tgbotapi.NewMessage(target.TelegramID, fmt.Sprintf(
+		"🔑 %v has made you an admin of @%v. Use /admin to see the admin options.",
+		html.EscapeString(args.User.DisplayName()), f.App.BotName,
+	))
+	notification.ParseMode = "HTML"
+	if _, err := f.App.Messenger.Send(notification); err != nil {
+		// bots can only message users who have started a chat with them
+		text += "\nThey could not be notified, they have to send /start to the bot first."
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, text)
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+// targetUser returns the user the admin subcommand is about: the author of the message the command replies to,
+// the user given after the subcommand or the one the admin is asked for.
+func (f *AdminCommand) targetUser(args *CommandArguments, question string) (*User, error) {
+	if msg := args.update.Message; msg != nil && msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil && !msg.ReplyToMessage.From.IsBot {
+		from := msg.ReplyToMessage.From
+		user := &User{}
+		if err := f.App.DB.Where("telegram_id = ?", from.ID).First(user).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			user.TelegramID = from.ID
+			user.Username = from.UserName
+			if err := f.App.DB.Save(user).Error; err != nil {
+				return nil, err
+			}
+		}
+		return user, nil
+	}
+	if len(args.Arguments) > 1 {
+		value, err := UserArgument{}.Parse(args, args.Arguments[1])
+		if err != nil {
+			return nil, err
+		}
+		return value.(*User), nil
+	}
+	value, err := args.AskForValue(question, UserArgument{})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*User), nil
+}
+
+func (f *AdminCommand) removeAdminUser(ctx context.Context, args *CommandArguments) error {
+	admins := []User{}
+	if err := f.App.DB.Where("is_admin = ?", true).Find(&admins).Error; err != nil {
+		return err
+	}
+	suggestions := map[string]string{}
+	for _, admin := range admins {
+		suggestions[fmt.Sprintf("%v", admin.TelegramID)] = fmt.Sprintf("%d %v", admin.TelegramID, admin.Username)
+	}
+	result, err := args.AskForValue("Select admin to remove:\n", IntArgument{}, suggestions)
 	if err != nil {
-		return fmt.Errorf("invalid user id: %w", err)
+		return err
 	}
+	userId := result.(int64)
 	user := &User{}
-	if err := f.App.DB.Where("telegram_id = ?", args.FromUserID).First(user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			user.TelegramID = args.FromUserID
-		} else {
-			return err
-		}
+	if err := f.App.DB.Where("telegram_id = ?", userId).First(user).Error; err != nil {
+		return err
+	}
+	err = args.Confirm(fmt.Sprintf("Are you sure you want to remove admin <b>%d</b> (%v)?", userId, user.Username))
+	if err != nil {
+		return err
+	}
+	_, err = f.App.UsersService.SetAdmin(user.TelegramID, false, args.User, "/admin")
+	return err
+}
+
+// userRoles are the roles which can be set with set_user_role,
+// admins are managed separately and keyholders are the users holding a key.
+var userRoles = []EnumValue{
+	{Value: RoleAnyone.String(), Label: "👤 No role"},
+	{Value: RoleMember.String(), Label: "🙋 Member"},
+}
+
+func (f *AdminCommand) setUserRole(ctx context.Context, args *CommandArguments) error {
+	user, err := f.targetUser(args, "Whose role should be changed? Forward a message of the user, share their contact or enter their @username or telegram user ID:")
+	if err != nil {
+		return err
+	}
+	result, err := args.AskForValue(fmt.Sprintf("Select the role of %v:", html.EscapeString(user.DisplayName())), EnumArgument{Values: userRoles})
+	if err != nil {
+		return err
 	}
-	user.IsAdmin = true
+	role := result.(string)
+	previous := user.Role()
+	user.IsMember = role == RoleMember.String()
 	if err := f.App.DB.Save(user).Error; err != nil {
 		return err
 	}
+	f.App.AuditService.RecordCommand(args, AuditActionRoleChanged, fmt.Sprintf("%v: %v -> %v", user.DisplayName(), previous, user.Role()))
 
-	username := "<unknown>"
-	if user.Username != "" {
-		username = user.Username
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("The role of %v is now <b>%v</b>", html.EscapeString(user.DisplayName()), user.Role()))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+const maxKeyLabelLength = 40
+
+func (f *AdminCommand) addKey(ctx context.Context, args *CommandArguments) error {
+	kinds := EnumArgument{}
+	for _, label := range KeyKindLabels {
+		kinds.Values = append(kinds.Values, EnumValue{Value: string(label.Kind), Label: label.Icon + " " + label.Name})
+	}
+	kind, err := args.AskForValue("What kind of key is it?", kinds)
+	if err != nil {
+		return err
+	}
+	label, err := args.AskForValue("Enter a label for the key, like <b>front door 2</b>:", ValidatedArgument{Validate: func(value string) error {
+		switch {
+		case strings.TrimSpace(value) == "":
+			return fmt.Errorf("the label cannot be empty")
+		case len(value) > maxKeyLabelLength:
+			// the label is sent back in the data of the buttons, which is limited to 64 bytes
+			return fmt.Errorf("the label can be at most %d characters long", maxKeyLabelLength)
+		}
+		return nil
+	}})
+	if err != nil {
+		return err
+	}
+	key, err := f.App.KeysService.Add(KeyKind(kind.(string)), strings.TrimSpace(label.(string)), args.User)
+	if err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("Added %v, use <b>Assign key</b> to give it to someone", html.EscapeString(key.String())))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+// askForKey asks for one of the keys matching the filter.
+func (f *AdminCommand) askForKey(args *CommandArguments, question string, filter func(key *Key) bool) (*Key, error) {
+	keys, err := f.App.KeysService.List()
+	if err != nil {
+		return nil, err
 	}
+	keyType := EnumArgument{}
+	for i := range keys {
+		if filter(&keys[i]) {
+			keyType.Values = append(keyType.Values, EnumValue{Value: keys[i].Label, Label: keys[i].String()})
+		}
+	}
+	if len(keyType.Values) == 0 {
+		return nil, fmt.Errorf("there are no such keys")
+	}
+	label, err := args.AskForValue(question, keyType)
+	if err != nil {
+		return nil, err
+	}
+	return f.App.KeysService.ByLabel(label.(string))
+}
 
-	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("Adding user with id <b>%d</b> (%v) as admin", parsedUserID, username))
+func (f *AdminCommand) assignKey(ctx context.Context, args *CommandArguments) error {
+	key, err := f.askForKey(args, "Which key should be assigned?", func(key *Key) bool { return true })
+	if err != nil {
+		return err
+	}
+	user, err := f.targetUser(args, fmt.Sprintf("Who gets %v? Forward a message of the user, share their contact or enter their @username or telegram user ID:", html.EscapeString(key.String())))
+	if err != nil {
+		return err
+	}
+	if key.Holder != nil {
+		if err := args.Confirm(fmt.Sprintf("%v is held by %v, has it been handed over?", html.EscapeString(key.String()), html.EscapeString(key.Holder.DisplayName()))); err != nil {
+			return err
+		}
+	}
+	if err := f.App.KeysService.Assign(key, user, args.User); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("%v is now held by %v", html.EscapeString(key.String()), html.EscapeString(user.DisplayName())))
 	msg.ParseMode = "HTML"
-	_, err = f.App.Bot.Send(msg)
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
 
+func (f *AdminCommand) returnKey(ctx context.Context, args *CommandArguments) error {
+	key, err := f.askForKey(args, "Which key has been returned?", func(key *Key) bool { return key.HolderID != nil })
+	if err != nil {
+		return err
+	}
+	if err := f.App.KeysService.Return(key, args.User); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("%v is back in the space", html.EscapeString(key.String())))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
 	return err
 }
 
-func (f *AdminCommand) removeAdminUser(ctx context.Context, args *CommandArguments) error {
-	admins := []User{}
-	if err := f.App.DB.Where("is_admin = ?", true).Find(&admins).Error; err != nil {
+func (f *AdminCommand) setNighthackTime(ctx context.Context, args *CommandArguments) error {
+	expr, err := f.askForSchedule(args, "nighthack time", f.App.SchedulerService.NighthackSchedule())
+	if err != nil {
 		return err
 	}
+	if err := f.App.SchedulerService.SetNighthackSchedule(expr); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("✅ Nighthack time set to <b>%v</b>", html.EscapeString(expr.String())))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+func (f *AdminCommand) setCallForVolunteersTime(ctx context.Context, args *CommandArguments) error {
+	expr, err := f.askForSchedule(args, "call for volunteers time", f.App.SchedulerService.CallForVolunteersSchedule())
+	if err != nil {
+		return err
+	}
+	if err := f.App.SchedulerService.SetCallForVolunteersSchedule(expr); err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("✅ Call for volunteers time set to <b>%v</b>", html.EscapeString(expr.String())))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+// askForSchedule asks the admin for a new schedule expression and confirms it.
+func (f *AdminCommand) askForSchedule(args *CommandArguments, what string, current *ScheduleExpression) (*ScheduleExpression, error) {
+	result, err := args.AskForValue(fmt.Sprintf(
+		"Current %v: <b>%v</b>\nEnter the new %v:",
+		what, html.EscapeString(scheduleString(current)), what,
+	), ScheduleArgument{})
+	if err != nil {
+		return nil, err
+	}
+	expr := result.(*ScheduleExpression)
+	exprStr := html.EscapeString(expr.String())
+	if cron, err := expr.CronString(); err == nil {
+		exprStr += fmt.Sprintf(" (cron <code>%v</code>)", html.EscapeString(cron))
+	}
+	preview := ""
+	for _, occurrence := range expr.NextOccurrences(time.Now().In(f.App.SchedulerService.Location()), defaultScheduleCount) {
+		preview += "\n• " + html.EscapeString(f.App.SchedulerService.FormatTime(occurrence))
+	}
+	if preview == "" {
+		preview = "\nnever"
+	}
+	err = args.Confirm(fmt.Sprintf(
+		"Set %v to <b>%v</b>?\nNext occurences (%v):%v",
+		what, exprStr, html.EscapeString(f.App.SchedulerService.Location().String()), preview,
+	))
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (f *AdminCommand) settings(ctx context.Context, args *CommandArguments) error {
+	settingsStr := ""
 	suggestions := map[string]string{}
-	for _, admin := range admins {
-		suggestions[fmt.Sprintf("%v", admin.TelegramID)] = fmt.Sprintf("%d %v", admin.TelegramID, admin.Username)
+	for _, def := range SettingDefinitions {
+		val, err := f.App.SettingsService.Get(def.Key)
+		if err != nil {
+			return err
+		}
+		settingsStr += fmt.Sprintf("<b>%v</b> = <code>%v</code> - %v\n", def.Key, html.EscapeString(val), html.EscapeString(def.Description))
+		suggestions[def.Key] = def.Key
+	}
+	key, err := args.AskForArgument("Current settings:\n"+settingsStr+"\nSelect setting to change:", suggestions)
+	if err != nil {
+		return err
 	}
-	userIdStr, err := f.App.AskService.AskForArgument(args.ChatID, "Select admin to remove:\n", suggestions)
+	def, err := f.App.SettingsService.Definition(key)
 	if err != nil {
 		return err
 	}
-	userId, err := strconv.ParseInt(userIdStr, 10, 64)
+	result, err := args.AskForValue(fmt.Sprintf("Enter new value for <b>%v</b>:", html.EscapeString(key)), ValidatedArgument{Validate: def.Validate})
 	if err != nil {
-		return fmt.Errorf("invalid user id: %w", err)
+		return err
 	}
-	user := &User{}
-	if err := f.App.DB.Where("telegram_id = ?", userId).First(user).Error; err != nil {
+	value := result.(string)
+	if err := f.App.SettingsService.Set(key, value); err != nil {
 		return err
 	}
-	err = f.App.AskService.Confirm(args.ChatID, fmt.Sprintf("Are you sure you want to remove admin <b>%d</b> (%v)?", userId, user.Username))
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("✅ <b>%v</b> set to <code>%v</code>", html.EscapeString(key), html.EscapeString(value)))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+func (f *AdminCommand) setAnnouncementChat(ctx context.Context, args *CommandArguments) error {
+	err := args.Confirm("Post calls for volunteers and nighthack announcements in this chat?")
 	if err != nil {
 		return err
 	}
-	user.IsAdmin = false
-	if err := f.App.DB.Save(user).Error; err != nil {
+	if err := f.App.SettingsService.Set(SettingAnnouncementChatID, strconv.FormatInt(args.ChatID, 10)); err != nil {
 		return err
 	}
+	_, err = f.App.Messenger.Send(tgbotapi.NewMessage(args.ChatID, "✅ Nighthacks will be announced in this chat"))
+	return err
+}
 
-	return nil
+func (f *AdminCommand) forceNextNighthack(ctx context.Context, args *CommandArguments) error {
+	return f.forceNextDecision(args, NighthackDecisionOn, "take place regardless of the volunteers")
+}
+
+func (f *AdminCommand) cancelNextNighthack(ctx context.Context, args *CommandArguments) error {
+	return f.forceNextDecision(args, NighthackDecisionCancelled, "be cancelled")
+}
+
+func (f *AdminCommand) forceNextDecision(args *CommandArguments, decision NighthackDecision, description string) error {
+	nighthack, err := f.App.SchedulerService.NextNighthack(time.Now())
+	if err != nil {
+		return err
+	}
+	if nighthack == nil {
+		return fmt.Errorf("no nighthack is scheduled")
+	}
+	err = args.Confirm(fmt.Sprintf(
+		"Should the nighthack on <b>%v</b> %v?",
+		html.EscapeString(f.App.SchedulerService.FormatTime(nighthack.StartsAt)), description,
+	))
+	if err != nil {
+		return err
+	}
+	if _, err := f.App.SchedulerService.ForceDecision(nighthack.ID, decision, args.User, time.Now()); err != nil {
+		return err
+	}
+	_, err = f.App.Messenger.Send(tgbotapi.NewMessage(args.ChatID, "✅ Override saved"))
+	return err
+}
+
+func (f *AdminCommand) overrideNextNighthackTime(ctx context.Context, args *CommandArguments) error {
+	nighthack, err := f.App.SchedulerService.NextNighthack(time.Now())
+	if err != nil {
+		return err
+	}
+	if nighthack == nil {
+		return fmt.Errorf("no nighthack is scheduled")
+	}
+	current := html.EscapeString(f.App.SchedulerService.FormatTime(nighthack.StartsAt))
+	if !nighthack.StartsAt.Equal(nighthack.OccurrenceAt) {
+		current += fmt.Sprintf(" (originally %v)", html.EscapeString(f.App.SchedulerService.FormatTime(nighthack.OccurrenceAt)))
+	}
+	result, err := args.AskForValue(fmt.Sprintf(
+		"The next nighthack is on <b>%v</b>.\nEnter the new date and time:",
+		current,
+	), TimeArgument{Future: true})
+	if err != nil {
+		return err
+	}
+	startsAt := result.(time.Time)
+	err = args.Confirm(fmt.Sprintf(
+		"Move the nighthack on <b>%v</b> to <b>%v</b>?\nThe recurring schedule stays unchanged.",
+		current, html.EscapeString(f.App.SchedulerService.FormatTime(startsAt)),
+	))
+	if err != nil {
+		return err
+	}
+	nighthack, err = f.App.SchedulerService.OverrideNextNighthack(startsAt, args.User, time.Now())
+	if err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("✅ The next nighthack will take place on <b>%v</b>", html.EscapeString(f.App.SchedulerService.FormatTime(nighthack.StartsAt))))
+	msg.ParseMode = "HTML"
+	_, err = f.App.Messenger.Send(msg)
+	return err
+}
+
+const (
+	// maxICalSize is the largest calendar which can be imported
+	maxICalSize = 1024 * 1024
+	// maxICalImportLines is how many changes and skipped events are listed before the import
+	maxICalImportLines = 30
+)
+
+func (f *AdminCommand) importICal(ctx context.Context, args *CommandArguments) error {
+	src, err := args.AskForArgument("Send the <b>.ics</b> file of the space calendar as a document:")
+	if err != nil {
+		return err
+	}
+	data, err := f.fetchICal(strings.TrimSpace(src))
+	if err != nil {
+		return fmt.Errorf("failed to download calendar: %w", err)
+	}
+	scheduler := f.App.SchedulerService
+	events, err := ParseICal(bytes.NewReader(data), scheduler.Location())
+	if err != nil {
+		return fmt.Errorf("failed to parse calendar: %w", err)
+	}
+	now := time.Now()
+	existing := []ScheduleException{}
+	if err := f.App.DB.Where("occurrence_at > ? OR starts_at > ?", now.UTC(), now.UTC()).Find(&existing).Error; err != nil {
+		return err
+	}
+	plan := PlanICalImport(events, scheduler.NighthackSchedule(), scheduler.Location(), existing, now)
+
+	text := fmt.Sprintf("📥 <b>Calendar import</b>: %d events, %d exceptions up to date\n", len(events), plan.Unchanged)
+	lines := []string{}
+	for _, change := range plan.Changes {
+		line := ""
+		switch {
+		case change.Remove:
+			line = "➖ " + formatScheduleException(scheduler, &change.Exception)
+		case change.Previous != nil:
+			line = "✏️ " + formatScheduleException(scheduler, &change.Exception) + " (was: " + formatScheduleException(scheduler, change.Previous) + ")"
+		default:
+			line = "➕ " + formatScheduleException(scheduler, &change.Exception)
+		}
+		lines = append(lines, line)
+	}
+	if len(plan.Skipped) > 0 {
+		lines = append(lines, "\nSkipped events:")
+	}
+	for _, skipped := range plan.Skipped {
+		lines = append(lines, fmt.Sprintf(
+			"• %v (%v): %v",
+			html.EscapeString(skipped.Event.Summary), html.EscapeString(scheduler.FormatTime(skipped.Event.Start)), html.EscapeString(skipped.Reason),
+		))
+	}
+	if len(lines) > maxICalImportLines {
+		lines = append(lines[:maxICalImportLines], fmt.Sprintf("… and %d more", len(lines)-maxICalImportLines))
+	}
+	text += "\n" + strings.Join(lines, "\n")
+	if len(plan.Changes) == 0 {
+		msg := tgbotapi.NewMessage(args.ChatID, text+"\n\nNothing to change.")
+		msg.ParseMode = "HTML"
+		_, err = f.App.Messenger.Send(msg)
+		return err
+	}
+	if err := args.Confirm(text + "\n\nApply these changes?"); err != nil {
+		return err
+	}
+	if err := scheduler.ApplyICalImport(plan, args.User); err != nil {
+		return err
+	}
+	_, err = f.App.Messenger.Send(tgbotapi.NewMessage(args.ChatID, fmt.Sprintf("✅ Imported %d changes", len(plan.Changes))))
+	return err
+}
+
+// fetchICal downloads a calendar sent to the bot as a document by its file ID.
+// Only Telegram is asked for the file, the bot does not fetch URLs given by users.
+func (f *AdminCommand) fetchICal(fileID string) ([]byte, error) {
+	if strings.Contains(fileID, "://") {
+		return nil, fmt.Errorf("calendars are not downloaded from URLs, download the .ics file and send it as a document")
+	}
+	url, err := f.App.Messenger.FileURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxICalSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxICalSize {
+		return nil, fmt.Errorf("the calendar is larger than %d bytes", maxICalSize)
+	}
+	return data, nil
+}
+
+// formatScheduleException describes a schedule exception for humans.
+func formatScheduleException(scheduler *SchedulerService, exception *ScheduleException) string {
+	result := ""
+	switch exception.Kind {
+	case ScheduleExceptionCancel:
+		result = "🚫 cancel " + html.EscapeString(scheduler.FormatTime(exception.OccurrenceAt))
+	case ScheduleExceptionMove:
+		result = fmt.Sprintf(
+			"🕑 move %v → %v",
+			html.EscapeString(scheduler.FormatTime(exception.OccurrenceAt)), html.EscapeString(scheduler.FormatTime(exception.StartsAt)),
+		)
+	case ScheduleExceptionExtra:
+		result = "🌙 extra nighthack " + html.EscapeString(scheduler.FormatTime(exception.StartsAt))
+	}
+	if exception.Summary != "" {
+		result += " – " + html.EscapeString(exception.Summary)
+	}
+	return result
 }
@@ -0,0 +1,103 @@
+package nighthackbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testAdminChatID = -100
+
+func TestAdminCommandDeniesNonAdmins(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	alice := &User{TelegramID: 10, Username: "alice"}
+
+	chat.send(alice, "/admin")
+	sent := messenger.Sent(testAdminChatID)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "permission denied") {
+		t.Fatalf("expected a permission denied error, got %+v", sent)
+	}
+	entry := &AuditLogEntry{}
+	if err := app.DB.Where("action = ?", AuditActionPermissionDenied).First(entry).Error; err != nil {
+		t.Fatalf("expected an audit log entry: %v", err)
+	}
+	if entry.TelegramID != alice.TelegramID || entry.Command != "/admin" {
+		t.Errorf("unexpected audit log entry %+v", entry)
+	}
+}
+
+func TestAdminCommandAddAdminUser(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	root := saveTestUser(t, app, &User{TelegramID: 1, Username: "root", IsAdmin: true})
+	bob := saveTestUser(t, app, &User{TelegramID: 2, Username: "bob"})
+
+	chat.send(root, "/admin add_admin_user @bob")
+	if err := app.DB.First(bob, "id = ?", bob.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !bob.IsAdmin {
+		t.Errorf("expected bob to be an admin")
+	}
+	if err := app.DB.First(root, "id = ?", root.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !root.IsAdmin {
+		t.Errorf("expected root to stay an admin")
+	}
+	if sent := messenger.Sent(bob.TelegramID); len(sent) != 1 || !strings.Contains(sent[0].Text, "made you an admin") {
+		t.Errorf("expected bob to be notified, got %+v", sent)
+	}
+	if sent := messenger.Sent(testAdminChatID); len(sent) != 1 || !strings.Contains(sent[0].Text, "@bob") {
+		t.Errorf("expected a confirmation in the chat, got %+v", sent)
+	}
+}
+
+func TestAdminCommandAddAdminUserByReply(t *testing.T) {
+	app, _ := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	root := saveTestUser(t, app, &User{TelegramID: 1, Username: "root", IsAdmin: true})
+	carol := &User{TelegramID: 3, Username: "carol"}
+
+	carolsMessage := chat.message(carol, "hi everyone")
+	msg := chat.message(root, "/admin add_admin_user")
+	msg.ReplyToMessage = carolsMessage
+	app.ProcessUpdate(context.Background(), updateWithMessage(msg))
+
+	user := &User{}
+	if err := app.DB.Where("telegram_id = ?", carol.TelegramID).First(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsAdmin || user.Username != "carol" {
+		t.Errorf("expected carol to be an admin, got %+v", user)
+	}
+}
+
+func TestFetchICalOnlyDownloadsDocuments(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+	app, messenger := newFakeBotApp(t)
+	messenger.Files = map[string]string{"document-file-id": server.URL + "/file/calendar.ics"}
+	command := &AdminCommand{App: app}
+
+	if _, err := command.fetchICal(server.URL + "/internal"); err == nil || !strings.Contains(err.Error(), "send it as a document") {
+		t.Errorf("expected a URL to be rejected, got %v", err)
+	}
+	if requests != 0 {
+		t.Fatalf("expected the URL not to be requested")
+	}
+	data, err := command.fetchICal("document-file-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "BEGIN:VCALENDAR") || requests != 1 {
+		t.Errorf("expected the document to be downloaded, got %q", data)
+	}
+}
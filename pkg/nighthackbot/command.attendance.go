@@ -0,0 +1,96 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var attendanceArguments = []*CommandDefArgument{
+	{
+		Name: "nighthack",
+	},
+}
+
+type CheckInCommand struct {
+	App *BotApp
+}
+
+func (s *CheckInCommand) Aliases() []string {
+	return []string{"/checkin"}
+}
+
+func (s *CheckInCommand) Arguments() []*CommandDefArgument {
+	return attendanceArguments
+}
+
+func (s *CheckInCommand) Help() string {
+	return "tells everyone you are in the space during a nighthack"
+}
+
+func (s *CheckInCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	nighthack, err := attendanceNighthack(s.App, args)
+	if err != nil {
+		return err
+	}
+	if err := s.App.AttendanceService.CheckIn(nighthack, args.User, time.Now()); err != nil {
+		return err
+	}
+	return replyAttendance(s.App, args, "🚪 You are checked in, have fun!")
+}
+
+type CheckOutCommand struct {
+	App *BotApp
+}
+
+func (s *CheckOutCommand) Aliases() []string {
+	return []string{"/checkout"}
+}
+
+func (s *CheckOutCommand) Arguments() []*CommandDefArgument {
+	return attendanceArguments
+}
+
+func (s *CheckOutCommand) Help() string {
+	return "tells everyone you have left the space"
+}
+
+func (s *CheckOutCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	nighthack, err := attendanceNighthack(s.App, args)
+	if err != nil {
+		return err
+	}
+	if err := s.App.AttendanceService.CheckOut(nighthack, args.User, time.Now()); err != nil {
+		return err
+	}
+	return replyAttendance(s.App, args, "👋 You are checked out, see you next time!")
+}
+
+// attendanceNighthack returns the nighthack of the button or the one running now.
+func attendanceNighthack(app *BotApp, args *CommandArguments) (*Nighthack, error) {
+	if id := args.namedArguments["nighthack"]; id != "" {
+		nighthack := &Nighthack{}
+		if err := app.DB.Where("id = ?", id).First(nighthack).Error; err != nil {
+			return nil, fmt.Errorf("failed to find nighthack: %w", err)
+		}
+		return nighthack, nil
+	}
+	nighthack, err := app.AttendanceService.RunningNighthack(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if nighthack == nil {
+		return nil, fmt.Errorf("no nighthack is running right now")
+	}
+	return nighthack, nil
+}
+
+func replyAttendance(app *BotApp, args *CommandArguments, reply string) error {
+	if answered, err := args.AnswerCallback(reply); answered {
+		return err
+	}
+	_, err := app.Messenger.Send(tgbotapi.NewMessage(args.ChatID, reply))
+	return err
+}
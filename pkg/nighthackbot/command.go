@@ -2,7 +2,10 @@ package nighthackbot
 
 import (
 	"context"
+	"fmt"
+	"html"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -12,6 +15,15 @@ type CommandDefArgument struct {
 	Description string
 	Question    string
 	Variadic    bool
+	// Type parses and validates the argument, arguments without a type accept any string.
+	Type ArgumentType
+}
+
+func (a *CommandDefArgument) argumentType() ArgumentType {
+	if a.Type == nil {
+		return StringArgument{}
+	}
+	return a.Type
 }
 
 type Command interface {
@@ -25,23 +37,87 @@ type Helpable interface {
 	Help() string
 }
 
+// CommandRequirements are the conditions under which a command may be executed.
+type CommandRequirements struct {
+	// Role is the lowest role allowed to execute the command.
+	Role Role
+	// ChatTypes limits the command to chats of these types (private, group, supergroup or channel), empty allows all.
+	ChatTypes []string
+}
+
+// RestrictedCommand is implemented by the commands which are not available to anyone everywhere.
+type RestrictedCommand interface {
+	Command
+	Requirements() CommandRequirements
+}
+
+// CommandRequirementsOf returns the requirements of the command, commands which are not restricted have none.
+func CommandRequirementsOf(cmd Command) CommandRequirements {
+	if restricted, ok := cmd.(RestrictedCommand); ok {
+		return restricted.Requirements()
+	}
+	return CommandRequirements{}
+}
+
+// ReplaySafeCommand is implemented by the commands which can be resumed after a restart. A resumed command is
+// executed again with the answers given so far, so it must not change anything before its last question.
+type ReplaySafeCommand interface {
+	Command
+	ReplaySafe(args *CommandArguments) bool
+}
+
+// commandIsReplaySafe reports whether the command of the arguments can be resumed after a restart.
+func commandIsReplaySafe(args *CommandArguments) bool {
+	if replaySafe, ok := args.Command.(ReplaySafeCommand); ok {
+		return replaySafe.ReplaySafe(args)
+	}
+	return false
+}
+
+// Check returns an error describing why the user cannot execute the command in a chat of the type.
+func (r CommandRequirements) Check(user *User, chatType string) error {
+	if !user.HasRole(r.Role) {
+		return fmt.Errorf("requires the %v role", r.Role)
+	}
+	if len(r.ChatTypes) == 0 {
+		return nil
+	}
+	for _, allowed := range r.ChatTypes {
+		if allowed == chatType {
+			return nil
+		}
+	}
+	return fmt.Errorf("can only be used in %v chats", strings.Join(r.ChatTypes, " or "))
+}
+
 type CommandArguments struct {
 	BotApp         *BotApp
 	update         *tgbotapi.Update
 	CommandName    string
 	Arguments      []string
 	ChatID         int64
+	ChatType       string // private, group, supergroup or channel
 	FromUserID     int64
 	FromUserName   string
 	namedArguments map[string]string
-	Command        Command
-	User           *User
+	// values are the parsed named arguments
+	values  map[string]interface{}
+	Command Command
+	User    *User
 }
 
-func (a *CommandArguments) GetOrAskForArgument(name string, suggestionsArr ...map[string]string) (string, error) {
-	if val, ok := a.namedArguments[name]; ok {
-		return val, nil
+// AnswerCallback answers the button press which executed the command and reports whether it did.
+// A command resumed after a restart cannot answer the press anymore and has to reply with a message.
+func (a *CommandArguments) AnswerCallback(text string) (bool, error) {
+	if a.update == nil || a.update.CallbackQuery == nil || a.update.CallbackQuery.ID == "" {
+		return false, nil
 	}
+	return true, a.BotApp.Messenger.AnswerCallback(a.update.CallbackQuery.ID, text)
+}
+
+// GetOrAskForArgument returns the named argument given with the command or asks the user for it.
+// Invalid values are asked for again with the validation error.
+func (a *CommandArguments) GetOrAskForArgument(name string, suggestionsArr ...map[string]string) (string, error) {
 	var cmdTemplate *CommandDefArgument
 	for _, arg := range a.Command.Arguments() {
 		if arg.Name == name {
@@ -50,13 +126,258 @@ func (a *CommandArguments) GetOrAskForArgument(name string, suggestionsArr ...ma
 		}
 	}
 	if cmdTemplate == nil {
-		return "", nil
+		return a.namedArguments[name], nil
+	}
+	argType := cmdTemplate.argumentType()
+	if input, ok := a.namedArguments[name]; ok {
+		value, err := argType.Parse(a, input)
+		if err == nil {
+			a.setValue(name, value)
+			return input, nil
+		}
+		// ask again, showing what was wrong
+		return a.askForArgument(name, cmdTemplate, fmt.Sprintf("🚫 %v\n", html.EscapeString(err.Error())), suggestionsArr...)
+	}
+	return a.askForArgument(name, cmdTemplate, "", suggestionsArr...)
+}
+
+func (a *CommandArguments) askForArgument(name string, cmdTemplate *CommandDefArgument, prefix string, suggestionsArr ...map[string]string) (string, error) {
+	question := cmdTemplate.Question
+	if question == "" {
+		question = fmt.Sprintf("Enter %v:", cmdTemplate.Name)
+		if cmdTemplate.Description != "" {
+			question = fmt.Sprintf("Enter %v (%v):", cmdTemplate.Name, cmdTemplate.Description)
+		}
+	}
+	input, value, err := a.askForValue(prefix+"❓ "+question, cmdTemplate.argumentType(), suggestionsArr...)
+	if err != nil {
+		return "", err
+	}
+	a.namedArguments[name] = input
+	a.setValue(name, value)
+	return input, nil
+}
+
+// AskForValue asks the user for a value of the type until a valid one is entered and returns the parsed value.
+func (a *CommandArguments) AskForValue(question string, argType ArgumentType, suggestionsArr ...map[string]string) (interface{}, error) {
+	_, value, err := a.askForValue(question, argType, suggestionsArr...)
+	return value, err
+}
+
+func (a *CommandArguments) askForValue(question string, argType ArgumentType, suggestionsArr ...map[string]string) (string, interface{}, error) {
+	if len(suggestionsArr) == 0 {
+		if suggester, ok := argType.(ArgumentSuggester); ok {
+			suggestionsArr = append(suggestionsArr, suggester.Suggestions())
+		}
+	}
+	if hint := argType.Hint(); hint != "" {
+		question += fmt.Sprintf(" <i>(%v)</i>", html.EscapeString(hint))
+	}
+	prefix := ""
+	for {
+		ask := a.BotApp.AskService.AskForArgument
+		if _, ok := argType.(UserArgument); ok {
+			ask = a.BotApp.AskService.AskForUser
+		}
+		input, err := ask(a.ChatID, a.FromUserID, prefix+question, suggestionsArr...)
+		if err != nil {
+			return "", nil, err
+		}
+		value, err := argType.Parse(a, input)
+		if err == nil {
+			return input, value, nil
+		}
+		prefix = fmt.Sprintf("🚫 %v\n", html.EscapeString(err.Error()))
+	}
+}
+
+func (a *CommandArguments) setValue(name string, value interface{}) {
+	if a.values == nil {
+		a.values = map[string]interface{}{}
+	}
+	a.values[name] = value
+}
+
+// Has reports whether the named argument was given with the command.
+func (a *CommandArguments) Has(name string) bool {
+	_, ok := a.namedArguments[name]
+	return ok
+}
+
+// Value returns the parsed named argument, asking for it if needed.
+func (a *CommandArguments) Value(name string) (interface{}, error) {
+	if value, ok := a.values[name]; ok {
+		return value, nil
+	}
+	if _, err := a.GetOrAskForArgument(name); err != nil {
+		return nil, err
+	}
+	if value, ok := a.values[name]; ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("unknown argument %q", name)
+}
+
+func (a *CommandArguments) Int64(name string) (int64, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return 0, err
+	}
+	if val, ok := value.(int64); ok {
+		return val, nil
+	}
+	return 0, fmt.Errorf("argument %q is not a number", name)
+}
+
+func (a *CommandArguments) Int(name string) (int, error) {
+	val, err := a.Int64(name)
+	return int(val), err
+}
+
+// UserRef returns the user referenced by the argument, which is not saved if the bot does not know them yet.
+func (a *CommandArguments) UserRef(name string) (*User, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := value.(*User); ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("argument %q is not a user", name)
+}
+
+func (a *CommandArguments) Duration(name string) (time.Duration, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return 0, err
+	}
+	if val, ok := value.(time.Duration); ok {
+		return val, nil
 	}
-	return a.BotApp.AskService.AskForArgument(a.ChatID, "❓ "+cmdTemplate.Question, suggestionsArr...)
+	return 0, fmt.Errorf("argument %q is not a duration", name)
+}
+
+// Time returns a time or date argument.
+func (a *CommandArguments) Time(name string) (time.Time, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if val, ok := value.(time.Time); ok {
+		return val, nil
+	}
+	return time.Time{}, fmt.Errorf("argument %q is not a time", name)
+}
+
+func (a *CommandArguments) Schedule(name string) (*ScheduleExpression, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return nil, err
+	}
+	if val, ok := value.(*ScheduleExpression); ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("argument %q is not a schedule", name)
+}
+
+// String returns a string or enum argument.
+func (a *CommandArguments) String(name string) (string, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return "", err
+	}
+	if val, ok := value.(string); ok {
+		return val, nil
+	}
+	return "", fmt.Errorf("argument %q is not a string", name)
+}
+
+func (a *CommandArguments) Bool(name string) (bool, error) {
+	value, err := a.Value(name)
+	if err != nil {
+		return false, err
+	}
+	if val, ok := value.(bool); ok {
+		return val, nil
+	}
+	return false, fmt.Errorf("argument %q is not yes or no", name)
+}
+
+// AskForArgument asks the user who executed the command a question in the chat.
+func (a *CommandArguments) AskForArgument(question string, suggestionsArr ...map[string]string) (string, error) {
+	return a.BotApp.AskService.AskForArgument(a.ChatID, a.FromUserID, question, suggestionsArr...)
+}
+
+// Confirm asks the user who executed the command to confirm an action.
+func (a *CommandArguments) Confirm(question string) error {
+	return a.BotApp.AskService.Confirm(a.ChatID, a.FromUserID, question)
+}
+
+// assignArguments fills the named arguments from the flags and then from the positional tokens in order.
+// A variadic argument takes the values of the remaining positional tokens, unquoted, joined with the whitespace
+// written between them, including newlines. Tokens separated by flags are joined with a single space.
+func (a *CommandArguments) assignArguments(src string, tokens []Token) error {
+	defs := a.Command.Arguments()
+	positional := []Token{}
+	for _, token := range tokens {
+		if token.Flag == "" {
+			positional = append(positional, token)
+			continue
+		}
+		known := false
+		for _, def := range defs {
+			known = known || def.Name == token.Flag
+		}
+		if !known {
+			return fmt.Errorf("unknown flag --%v", token.Flag)
+		}
+		a.namedArguments[token.Flag] = token.Value
+	}
+	a.Arguments = []string{}
+	for _, token := range positional {
+		a.Arguments = append(a.Arguments, token.Value)
+	}
+	i := 0
+	for _, def := range defs {
+		if _, ok := a.namedArguments[def.Name]; ok {
+			continue
+		}
+		if i >= len(positional) {
+			break
+		}
+		if def.Variadic {
+			a.namedArguments[def.Name] = joinTokens(src, positional[i:])
+			break
+		}
+		a.namedArguments[def.Name] = positional[i].Value
+		i++
+	}
+	return nil
+}
+
+// joinTokens joins the values of the tokens keeping the whitespace between them in the command text,
+// tokens separated by flags are joined with a space.
+func joinTokens(src string, tokens []Token) string {
+	value := strings.Builder{}
+	for i, token := range tokens {
+		if i > 0 {
+			separator := src[tokens[i-1].End:token.Start]
+			if strings.TrimSpace(separator) != "" {
+				separator = " "
+			}
+			value.WriteString(separator)
+		}
+		value.WriteString(token.Value)
+	}
+	return value.String()
 }
 
 func CommandMatches(BotApp *BotApp, cmd Command, userInput string) bool {
-	usersCmd := strings.Split(userInput, " ")[0]
+	fields := strings.Fields(userInput)
+	if len(fields) == 0 {
+		return false
+	}
+	usersCmd := fields[0]
 	// strip bot suffix on groups
 	usersCmd = strings.TrimSuffix(usersCmd, "@"+BotApp.BotName)
 	for _, alias := range cmd.Aliases() {
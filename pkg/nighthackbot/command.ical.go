@@ -0,0 +1,41 @@
+package nighthackbot
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type ICalCommand struct {
+	App *BotApp
+}
+
+func (s *ICalCommand) Aliases() []string {
+	return []string{"/ical"}
+}
+
+func (s *ICalCommand) Arguments() []*CommandDefArgument {
+	return []*CommandDefArgument{}
+}
+
+func (s *ICalCommand) Help() string {
+	return "sends the nighthacks as an iCalendar file"
+}
+
+func (s *ICalCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	export, err := s.App.SchedulerService.ICalExport(time.Now())
+	if err != nil {
+		return err
+	}
+	doc := tgbotapi.NewDocument(args.ChatID, tgbotapi.FileBytes{
+		Name:  "nighthacks.ics",
+		Bytes: []byte(export.Render()),
+	})
+	doc.Caption = "🗓 Import this file into your calendar"
+	if url := s.App.HTTPService.URL(icalPath); url != "" {
+		doc.Caption += " or subscribe to " + url
+	}
+	_, err = s.App.Messenger.Send(doc)
+	return err
+}
@@ -0,0 +1,59 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type KeysCommand struct {
+	App *BotApp
+}
+
+func (s *KeysCommand) Aliases() []string {
+	return []string{"/keys"}
+}
+
+func (s *KeysCommand) Arguments() []*CommandDefArgument {
+	return []*CommandDefArgument{}
+}
+
+func (s *KeysCommand) Requirements() CommandRequirements {
+	return CommandRequirements{Role: RoleMember}
+}
+
+func (s *KeysCommand) Help() string {
+	return "lists the keys of the space and who holds them"
+}
+
+func (s *KeysCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	keys, err := s.App.KeysService.List()
+	if err != nil {
+		return err
+	}
+	text := "<b>Keys</b>\n"
+	if len(keys) == 0 {
+		text += "\nNo keys are registered, admins can add them with /admin"
+	}
+	for i := range keys {
+		text += "\n" + formatKey(s.App.SchedulerService, &keys[i])
+	}
+	msg := tgbotapi.NewMessage(args.ChatID, text)
+	msg.ParseMode = "HTML"
+	_, err = s.App.Messenger.Send(msg)
+	return err
+}
+
+func formatKey(scheduler *SchedulerService, key *Key) string {
+	line := html.EscapeString(key.String()) + " — "
+	if key.Holder == nil {
+		return line + "<i>in the space</i>"
+	}
+	line += html.EscapeString(key.Holder.DisplayName())
+	if key.HeldSince != nil {
+		line += fmt.Sprintf(" since %v", html.EscapeString(scheduler.FormatTime(*key.HeldSince)))
+	}
+	return line
+}
@@ -0,0 +1,91 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultScheduleCount = 5
+	maxScheduleCount     = 20
+)
+
+type ScheduleCommand struct {
+	App *BotApp
+}
+
+func (s *ScheduleCommand) Aliases() []string {
+	return []string{"/schedule"}
+}
+
+func (s *ScheduleCommand) Arguments() []*CommandDefArgument {
+	return []*CommandDefArgument{{
+		Name:        "count",
+		Description: "how many nighthacks to show",
+		Type:        IntRange(1, maxScheduleCount),
+	}}
+}
+
+func (s *ScheduleCommand) Help() string {
+	return "lists the upcoming nighthacks"
+}
+
+func (s *ScheduleCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	count := defaultScheduleCount
+	if args.Has("count") {
+		var err error
+		if count, err = args.Int("count"); err != nil {
+			return err
+		}
+	}
+	upcoming, err := s.App.SchedulerService.UpcomingNighthacks(time.Now(), count)
+	if err != nil {
+		return err
+	}
+	if len(upcoming) == 0 {
+		_, err = s.App.Messenger.Send(tgbotapi.NewMessage(args.ChatID, "No nighthacks are scheduled"))
+		return err
+	}
+
+	text := fmt.Sprintf("🗓 <b>Upcoming nighthacks</b> (%v)\n", html.EscapeString(s.App.SchedulerService.Location().String()))
+	for _, nighthack := range upcoming {
+		text += "\n" + formatScheduledNighthack(s.App.SchedulerService, &nighthack)
+	}
+	text += fmt.Sprintf(
+		"\n\nNighthack time: <code>%v</code>\nCall for volunteers time: <code>%v</code>",
+		html.EscapeString(scheduleString(s.App.SchedulerService.NighthackSchedule())),
+		html.EscapeString(scheduleString(s.App.SchedulerService.CallForVolunteersSchedule())),
+	)
+	msg := tgbotapi.NewMessage(args.ChatID, text)
+	msg.ParseMode = "HTML"
+	_, err = s.App.Messenger.Send(msg)
+	return err
+}
+
+// formatScheduledNighthack renders a single line of the schedule with the overrides and cancellations flagged.
+func formatScheduledNighthack(scheduler *SchedulerService, nighthack *ScheduledNighthack) string {
+	line := "• <b>" + html.EscapeString(scheduler.FormatTime(nighthack.StartsAt)) + "</b>"
+	if nighthack.Cancelled {
+		line = "• <s>" + html.EscapeString(scheduler.FormatTime(nighthack.StartsAt)) + "</s> 🚫 cancelled"
+	}
+	if nighthack.Moved {
+		line += fmt.Sprintf(" 🕑 moved from %v", html.EscapeString(scheduler.FormatTime(nighthack.OccurrenceAt)))
+	}
+	if nighthack.Extra {
+		line += " 🌙 extra"
+	}
+	if nighthack.Forced {
+		line += " 💪 forced"
+	}
+	if nighthack.Summary != "" {
+		line += " – " + html.EscapeString(nighthack.Summary)
+	}
+	if !nighthack.CallForVolunteersAt.IsZero() && !nighthack.Cancelled {
+		line += fmt.Sprintf("\n   📣 call for volunteers: %v", html.EscapeString(scheduler.FormatTime(nighthack.CallForVolunteersAt)))
+	}
+	return line
+}
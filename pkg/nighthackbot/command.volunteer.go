@@ -0,0 +1,91 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type VolunteerCommand struct {
+	App *BotApp
+}
+
+func (s *VolunteerCommand) Aliases() []string {
+	return []string{"/volunteer"}
+}
+
+func (s *VolunteerCommand) Arguments() []*CommandDefArgument {
+	statusType := EnumArgument{}
+	for _, label := range VolunteerStatusLabels {
+		statusType.Values = append(statusType.Values, EnumValue{Value: string(label.Status), Label: label.Button})
+	}
+	return []*CommandDefArgument{
+		{
+			Name:        "status",
+			Description: "open, attend or no",
+			Question:    "Can you open the space (open), will you attend (attend) or can't you make it (no)?",
+			Type:        statusType,
+		},
+		{
+			Name: "nighthack",
+		},
+	}
+}
+
+func (s *VolunteerCommand) Help() string {
+	return "signs up for the next nighthack"
+}
+
+// ReplaySafe reports that the command can be resumed after a restart, it only saves the answer after asking for it.
+func (s *VolunteerCommand) ReplaySafe(args *CommandArguments) bool {
+	return true
+}
+
+func (s *VolunteerCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	statusStr, err := args.String("status")
+	if err != nil {
+		return err
+	}
+	status := VolunteerStatus(statusStr)
+
+	now := time.Now()
+	nighthack := &Nighthack{}
+	if id := args.namedArguments["nighthack"]; id != "" {
+		if err := s.App.DB.Where("id = ?", id).First(nighthack).Error; err != nil {
+			return fmt.Errorf("failed to find nighthack: %w", err)
+		}
+	} else {
+		nighthack, err = s.App.SchedulerService.NextNighthack(now)
+		if err != nil {
+			return err
+		}
+		if nighthack == nil {
+			return fmt.Errorf("no nighthack is scheduled")
+		}
+	}
+	if !nighthack.StartsAt.After(now) {
+		return fmt.Errorf("this nighthack has already started")
+	}
+
+	if err := s.App.VolunteerService.SetStatus(nighthack, args.User, status); err != nil {
+		return err
+	}
+
+	reply := fmt.Sprintf("Your answer for the nighthack on %v has been saved", s.App.SchedulerService.FormatTime(nighthack.StartsAt))
+	if status == VolunteerStatusOpen {
+		keyRegistry, err := s.App.VolunteerService.usesKeyRegistry()
+		if err != nil {
+			return err
+		}
+		if !canOpen(args.User, keyRegistry) {
+			reply += ", but you don't hold a key so you are counted as attending"
+		}
+	}
+	if answered, err := args.AnswerCallback(reply); answered {
+		return err
+	}
+	_, err = s.App.Messenger.Send(tgbotapi.NewMessage(args.ChatID, "✅ "+reply))
+	return err
+}
@@ -0,0 +1,50 @@
+package nighthackbot
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVolunteerCommandSameStatusTwice(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	var edits atomic.Int32
+	messenger.EditError = func(msg FakeMessage) error {
+		edits.Add(1)
+		return errors.New("Bad Request: message is not modified")
+	}
+	chat := newFakeChat(app, testAnnouncementChatID, "supergroup")
+	alice := saveTestUser(t, app, &User{TelegramID: 2, Username: "alice"})
+	startsAt := time.Now().Add(48 * time.Hour)
+	nighthack := saveTestNighthack(t, app, &Nighthack{StartsAt: startsAt, CallMessageChatID: testAnnouncementChatID, CallMessageID: 10})
+	call := FakeMessage{ChatID: testAnnouncementChatID, MessageID: 10}
+
+	for i := 0; i < 2; i++ {
+		chat.press(alice, call, "/volunteer attend "+nighthack.ID)
+	}
+
+	answered := 0
+	for _, msg := range messenger.Messages() {
+		switch {
+		case msg.Kind == FakeMessageCallback && strings.Contains(msg.Text, "has been saved"):
+			answered++
+		case strings.Contains(msg.Text, "Error"):
+			t.Errorf("expected no error, got %q", msg.Text)
+		}
+	}
+	if answered != 2 {
+		t.Errorf("expected both presses to be answered, got %d answers", answered)
+	}
+	if n := edits.Load(); n != 1 {
+		t.Errorf("expected the message to be edited only when the answer changed, got %d edits", n)
+	}
+	volunteers, err := app.VolunteerService.List(nighthack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volunteers) != 1 || volunteers[0].Status != VolunteerStatusAttend {
+		t.Errorf("expected alice to attend once, got %+v", volunteers)
+	}
+}
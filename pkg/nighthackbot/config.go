@@ -1,13 +1,38 @@
 package nighthackbot
 
 type Config struct {
+	// Admins are the telegram IDs of the users who are made admins on startup, so the first admin can use /admin
+	Admins   []int64 `mapstructure:"admins"`
 	Telegram struct {
 		Token string `mapstructure:"token"`
 		Debug bool   `mapstructure:"debug"`
+		// APIEndpoint is the format of the Bot API URLs, for example http://localhost:8081/bot%s/%s for a local Bot API server
+		APIEndpoint string `mapstructure:"api_endpoint"`
+		// Webhook makes Telegram push the updates to the bot instead of the bot polling for them
+		Webhook struct {
+			Listen      string `mapstructure:"listen"`       // for example :8443, long polling is used if empty
+			PublicURL   string `mapstructure:"public_url"`   // where Telegram posts the updates, for example https://bot.example.com/telegram
+			SecretToken string `mapstructure:"secret_token"` // checked on every update, a random one is used if empty
+			CertFile    string `mapstructure:"cert_file"`    // serves HTTPS and uploads the certificate to Telegram if set (for self-signed certificates)
+			KeyFile     string `mapstructure:"key_file"`
+		} `mapstructure:"webhook"`
 	} `mapstructure:"telegram"`
 	DB struct {
 		Type     string `mapstructure:"type"`
 		DSN      string `mapstructure:"dsn"`      // postgres
 		Filename string `mapstructure:"filename"` // sqlite
 	} `mapstructure:"db"`
+	Nighthack struct {
+		AnnouncementChatID int64  `mapstructure:"announcement_chat_id"` // where calls for volunteers and announcements are posted
+		Timezone           string `mapstructure:"timezone"`             // for example Europe/Warsaw, defaults to UTC
+	} `mapstructure:"nighthack"`
+	// Updates limit the processing of the incoming updates, the updates of each chat are processed in order
+	Updates struct {
+		Workers   int `mapstructure:"workers"`    // updates processed at once, defaults to 8
+		QueueSize int `mapstructure:"queue_size"` // updates waiting for a worker before receiving stops, defaults to 100
+	} `mapstructure:"updates"`
+	HTTP struct {
+		Listen    string `mapstructure:"listen"`     // for example :8080, the HTTP server is disabled if empty
+		PublicURL string `mapstructure:"public_url"` // the address the server is reachable at, for example https://bot.example.com
+	} `mapstructure:"http"`
 }
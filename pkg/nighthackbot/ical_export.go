@@ -0,0 +1,377 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	icalDateTimeLayout = "20060102T150405"
+	// icalHistory is how far back the exported calendar starts
+	icalHistory = time.Hour * 24 * 28
+	// icalHorizon is how far ahead exceptions and non-recurring occurences are exported
+	icalHorizon = time.Hour * 24 * 366 * 2
+	// icalTimezoneYears is how many years of time zone transitions are exported
+	icalTimezoneYears = 10
+)
+
+var icalWeekdays = []struct {
+	mask WeekdayMask
+	name string
+}{
+	{WeekdayMonday, "MO"},
+	{WeekdayTuesday, "TU"},
+	{WeekdayWednesday, "WE"},
+	{WeekdayThursday, "TH"},
+	{WeekdayFriday, "FR"},
+	{WeekdaySaturday, "SA"},
+	{WeekdaySunday, "SU"},
+}
+
+// ICalExport renders the nighthack schedule as an RFC 5545 calendar.
+type ICalExport struct {
+	Schedule *ScheduleExpression
+	// Location is used for the leafs without their own time zone.
+	Location *time.Location
+	Duration time.Duration
+	// Exceptions are the moved, skipped and extra occurences.
+	Exceptions []ScheduleException
+	// Cancelled are the occurences which do not take place.
+	Cancelled []time.Time
+	Now       time.Time
+}
+
+// icalEvent is a VEVENT generated from one leaf of the schedule.
+type icalEvent struct {
+	leaf     *ScheduleExpressionLeaf
+	location *time.Location
+	dtstart  time.Time
+	rrule    string
+	exdates  []time.Time
+	rdates   []time.Time
+}
+
+func (e *ICalExport) Render() string {
+	w := &icalWriter{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//alufers//nighthack-bot//EN")
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	w.line("X-WR-CALNAME:Nighthacks")
+
+	events := e.events()
+	locations := map[string]*time.Location{}
+	for _, event := range events {
+		if event.location != time.UTC {
+			locations[event.location.String()] = event.location
+		}
+	}
+	names := []string{}
+	for name := range locations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	from := e.Now.Add(-icalHistory)
+	for _, name := range names {
+		writeVTimezone(w, locations[name], from, from.AddDate(icalTimezoneYears, 0, 0))
+	}
+
+	for i, event := range events {
+		w.line("BEGIN:VEVENT")
+		description := "Extra nighthacks"
+		if event.leaf != nil {
+			description = "Schedule: " + event.leaf.String()
+		}
+		w.line(fmt.Sprintf("UID:nighthack-%d-%x@nighthack-bot", i, leafHash(event.leaf)))
+		w.line("DTSTAMP:" + e.Now.UTC().Format(icalDateTimeLayout) + "Z")
+		w.line(icalTimeProperty("DTSTART", event.location, event.dtstart))
+		w.line("DURATION:" + icalDuration(e.Duration))
+		w.line("SUMMARY:" + icalEscape("Nighthack"))
+		w.line("DESCRIPTION:" + icalEscape(description))
+		if event.rrule != "" {
+			w.line("RRULE:" + event.rrule)
+		}
+		for _, t := range event.rdates {
+			w.line(icalTimeProperty("RDATE", event.location, t))
+		}
+		for _, t := range event.exdates {
+			w.line(icalTimeProperty("EXDATE", event.location, t))
+		}
+		w.line("END:VEVENT")
+	}
+	w.line("END:VCALENDAR")
+	return w.String()
+}
+
+func (e *ICalExport) events() []*icalEvent {
+	from := e.Now.Add(-icalHistory)
+	to := e.Now.Add(icalHorizon)
+	events := []*icalEvent{}
+	leafs := []*ScheduleExpressionLeaf{}
+	if e.Schedule != nil {
+		leafs = e.Schedule.Leafs
+	}
+	for _, leaf := range leafs {
+		location := leaf.Location
+		if location == nil {
+			location = e.Location
+		}
+		dtstart := leaf.GetNextOccurence(from.In(location))
+		if dtstart.IsZero() {
+			continue
+		}
+		event := &icalEvent{
+			leaf:     leaf,
+			location: location,
+			dtstart:  dtstart,
+			rrule:    leafRRule(leaf),
+		}
+		if event.rrule == "" {
+			// the occurences have to be listed one by one
+			single := &ScheduleExpression{Leafs: []*ScheduleExpressionLeaf{leaf}}
+			occurrences := single.Occurrences(dtstart, to)
+			event.rdates = append(event.rdates, occurrences...)
+		} else if len(leaf.Except) > 0 {
+			// the recurrence rule has no exceptions, exclude them explicitly
+			withoutExcept := *leaf
+			withoutExcept.Except = nil
+			single := &ScheduleExpression{Leafs: []*ScheduleExpressionLeaf{&withoutExcept}}
+			for _, t := range single.Occurrences(dtstart.Add(-time.Second), to) {
+				if !leaf.matchesDay(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)) {
+					event.exdates = append(event.exdates, t)
+				}
+			}
+		}
+		events = append(events, event)
+	}
+
+	// moved and cancelled occurences are excluded from the event they belong to, the new times are added to it
+	exceptions := append([]ScheduleException{}, e.Exceptions...)
+	sort.Slice(exceptions, func(i, j int) bool {
+		return exceptions[i].StartsAt.Before(exceptions[j].StartsAt)
+	})
+	for _, exception := range exceptions {
+		event := eventOfOccurrence(events, exception.OccurrenceAt)
+		if event != nil && exception.Kind != ScheduleExceptionExtra {
+			event.exdates = append(event.exdates, exception.OccurrenceAt.In(event.location))
+		}
+		if exception.Kind == ScheduleExceptionCancel {
+			continue
+		}
+		if event == nil {
+			if len(events) == 0 {
+				// without a schedule the extra nighthacks get an event of their own
+				events = append(events, &icalEvent{location: e.Location, dtstart: exception.StartsAt.In(e.Location)})
+				continue
+			}
+			event = events[0]
+		}
+		event.rdates = append(event.rdates, exception.StartsAt.In(event.location))
+	}
+	for _, cancelled := range e.Cancelled {
+		if event := eventOfOccurrence(events, cancelled); event != nil {
+			event.exdates = append(event.exdates, cancelled.In(event.location))
+		} else if event := eventWithRDate(events, cancelled); event != nil {
+			event.exdates = append(event.exdates, cancelled.In(event.location))
+		}
+	}
+	for _, event := range events {
+		sortTimes(event.rdates)
+		sortTimes(event.exdates)
+	}
+	return events
+}
+
+// eventOfOccurrence finds the event generated from the leaf the occurrence belongs to.
+func eventOfOccurrence(events []*icalEvent, occurrence time.Time) *icalEvent {
+	for _, event := range events {
+		if event.leaf != nil && event.leaf.GetNextOccurence(occurrence.Add(-time.Second).In(event.location)).Equal(occurrence) {
+			return event
+		}
+	}
+	return nil
+}
+
+func eventWithRDate(events []*icalEvent, t time.Time) *icalEvent {
+	for _, event := range events {
+		for _, rdate := range event.rdates {
+			if rdate.Equal(t) {
+				return event
+			}
+		}
+	}
+	return nil
+}
+
+// leafRRule returns the recurrence rule of the leaf or an empty string if it cannot be expressed as one.
+func leafRRule(leaf *ScheduleExpressionLeaf) string {
+	days := []string{}
+	for _, weekday := range icalWeekdays {
+		if leaf.WeekdayMask&weekday.mask != 0 {
+			days = append(days, weekday.name)
+		}
+	}
+	allDays := leaf.WeekdayMask&AllWeekdays == AllWeekdays
+	parts := []string{}
+	switch {
+	case leaf.Nth != 0:
+		if leaf.IntervalWeeks > 1 {
+			return ""
+		}
+		nthDays := []string{}
+		for _, day := range days {
+			nthDays = append(nthDays, strconv.Itoa(leaf.Nth)+day)
+		}
+		parts = append(parts, "FREQ=MONTHLY", "BYDAY="+strings.Join(nthDays, ","))
+		if leaf.MonthDays != 0 {
+			parts = append(parts, "BYMONTHDAY="+joinInts(maskToList(uint64(leaf.MonthDays), 1, 31)))
+		}
+	case leaf.MonthDays != 0:
+		if leaf.IntervalWeeks > 1 {
+			return ""
+		}
+		parts = append(parts, "FREQ=MONTHLY", "BYMONTHDAY="+joinInts(maskToList(uint64(leaf.MonthDays), 1, 31)))
+		if !allDays {
+			parts = append(parts, "BYDAY="+strings.Join(days, ","))
+		}
+	case allDays && leaf.IntervalWeeks <= 1:
+		parts = append(parts, "FREQ=DAILY")
+	default:
+		parts = append(parts, "FREQ=WEEKLY")
+		if leaf.IntervalWeeks > 1 {
+			parts = append(parts, "INTERVAL="+strconv.Itoa(leaf.IntervalWeeks))
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","), "WKST=MO")
+	}
+	if leaf.Months != 0 {
+		parts = append(parts, "BYMONTH="+joinInts(maskToList(uint64(leaf.Months), 1, 12)))
+	}
+	return strings.Join(parts, ";")
+}
+
+func leafHash(leaf *ScheduleExpressionLeaf) uint32 {
+	h := fnv.New32a()
+	if leaf != nil {
+		h.Write([]byte(leaf.String()))
+	}
+	return h.Sum32()
+}
+
+func joinInts(values []int) string {
+	strs := []string{}
+	for _, v := range values {
+		strs = append(strs, strconv.Itoa(v))
+	}
+	return strings.Join(strs, ",")
+}
+
+func sortTimes(times []time.Time) {
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+}
+
+func icalTimeProperty(name string, location *time.Location, t time.Time) string {
+	if location == time.UTC {
+		return name + ":" + t.UTC().Format(icalDateTimeLayout) + "Z"
+	}
+	return name + ";TZID=" + location.String() + ":" + t.In(location).Format(icalDateTimeLayout)
+}
+
+func icalDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes <= 0 {
+		minutes = 1
+	}
+	result := "PT"
+	if minutes >= 60 {
+		result += strconv.Itoa(minutes/60) + "H"
+	}
+	if minutes%60 != 0 {
+		result += strconv.Itoa(minutes%60) + "M"
+	}
+	return result
+}
+
+func icalOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+}
+
+func icalEscape(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// writeVTimezone writes the observances of the location between from and to.
+func writeVTimezone(w *icalWriter, location *time.Location, from time.Time, to time.Time) {
+	w.line("BEGIN:VTIMEZONE")
+	w.line("TZID:" + location.String())
+
+	writeObservance := func(t time.Time, offsetFrom int) {
+		local := t.In(location)
+		name, offset := local.Zone()
+		kind := "STANDARD"
+		if local.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		w.line("BEGIN:" + kind)
+		// the start of an observance is the local time before the transition
+		w.line("DTSTART:" + t.UTC().Add(time.Duration(offsetFrom)*time.Second).Format(icalDateTimeLayout))
+		w.line("TZOFFSETFROM:" + icalOffset(offsetFrom))
+		w.line("TZOFFSETTO:" + icalOffset(offset))
+		w.line("TZNAME:" + name)
+		w.line("END:" + kind)
+	}
+
+	_, offset := from.In(location).Zone()
+	writeObservance(from, offset)
+	for t := from; t.Before(to); t = t.Add(time.Hour * 24) {
+		_, before := t.In(location).Zone()
+		next := t.Add(time.Hour * 24)
+		if _, after := next.In(location).Zone(); after == before {
+			continue
+		}
+		// find the exact second of the transition
+		lo, hi := t, next
+		for hi.Sub(lo) > time.Second {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			if _, midOffset := mid.In(location).Zone(); midOffset == before {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		writeObservance(hi, before)
+	}
+	w.line("END:VTIMEZONE")
+}
+
+// icalWriter writes content lines folded at 75 octets and terminated with CRLF.
+type icalWriter struct {
+	strings.Builder
+}
+
+func (w *icalWriter) line(content string) {
+	const maxLineLength = 75
+	limit := maxLineLength
+	for len(content) > limit {
+		cut := limit
+		// do not split multi-byte characters
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(content[:cut] + "\r\n ")
+		content = content[cut:]
+		limit = maxLineLength - 1
+	}
+	w.WriteString(content + "\r\n")
+}
@@ -0,0 +1,73 @@
+package nighthackbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestICalExportRRule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		rrule    string
+	}{
+		{"friday 18:00", "FREQ=WEEKLY;BYDAY=FR;WKST=MO"},
+		{"everyday 18:00", "FREQ=DAILY"},
+		{"every 2 weeks tuesday 19:00 from 2026-01-06", "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU;WKST=MO"},
+		{"last friday 18:00", "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"1st 15th 18:00", "FREQ=MONTHLY;BYMONTHDAY=1,15"},
+		{"0 18 * 6-8 5", "FREQ=WEEKLY;BYDAY=FR;WKST=MO;BYMONTH=6,7,8"},
+	}
+	for _, test := range tests {
+		parsed, err := ParseScheduleExpression(test.schedule)
+		if err != nil {
+			t.Fatalf("%s: %v", test.schedule, err)
+		}
+		if rrule := leafRRule(parsed.Leafs[0]); rrule != test.rrule {
+			t.Errorf("%s: expected %s, got %s", test.schedule, test.rrule, rrule)
+		}
+	}
+}
+
+func TestICalExportRender(t *testing.T) {
+	schedule, err := ParseScheduleExpression("friday 18:00 Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse(time.RFC3339, "2026-03-01T12:00:00Z")
+	warsaw, _ := time.LoadLocation("Europe/Warsaw")
+	export := &ICalExport{
+		Schedule: schedule,
+		Location: time.UTC,
+		Duration: time.Hour * 6,
+		Exceptions: []ScheduleException{{
+			Kind:         ScheduleExceptionMove,
+			OccurrenceAt: time.Date(2026, 3, 13, 18, 0, 0, 0, warsaw),
+			StartsAt:     time.Date(2026, 3, 14, 17, 0, 0, 0, warsaw),
+		}},
+		Cancelled: []time.Time{time.Date(2026, 3, 20, 18, 0, 0, 0, warsaw)},
+		Now:       now,
+	}
+	rendered := export.Render()
+	for _, line := range []string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Warsaw",
+		"DTSTART:20260329T020000",
+		"TZOFFSETTO:+0200",
+		"DTSTART;TZID=Europe/Warsaw:20260206T180000",
+		"DURATION:PT6H",
+		"RRULE:FREQ=WEEKLY;BYDAY=FR;WKST=MO",
+		"RDATE;TZID=Europe/Warsaw:20260314T170000",
+		"EXDATE;TZID=Europe/Warsaw:20260313T180000",
+		"EXDATE;TZID=Europe/Warsaw:20260320T180000",
+	} {
+		if !strings.Contains(rendered, line+"\r\n") {
+			t.Errorf("expected line %q in:\n%s", line, rendered)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(rendered, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+}
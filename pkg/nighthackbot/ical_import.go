@@ -0,0 +1,367 @@
+package nighthackbot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const icalDateLayout = "20060102"
+
+// icalClosureKeywords mark events which cancel the nighthacks during them, like holidays or space closures.
+var icalClosureKeywords = []string{"no nighthack", "closed", "closure", "cancel", "holiday"}
+
+// ICalEvent is a VEVENT read from an imported calendar.
+type ICalEvent struct {
+	UID     string
+	Summary string
+	Status  string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+	// RecurrenceID is set when the event overrides a single instance of a recurring event.
+	RecurrenceID time.Time
+	// Recurring is set for events with RRULE or RDATE, which are not imported.
+	Recurring bool
+}
+
+// Key identifies the event, including the instance of a recurring event.
+func (e *ICalEvent) Key() string {
+	if e.RecurrenceID.IsZero() {
+		return e.UID
+	}
+	return e.UID + "/" + e.RecurrenceID.UTC().Format(icalDateTimeLayout) + "Z"
+}
+
+// ParseICal reads the events of an RFC 5545 calendar.
+// Floating times and unknown time zones are interpreted in location.
+func ParseICal(r io.Reader, location *time.Location) ([]ICalEvent, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return nil, err
+	}
+	events := []ICalEvent{}
+	components := []string{}
+	foundCalendar := false
+	var event *ICalEvent
+	var duration time.Duration
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		name, params, value, err := parseICalLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		switch name {
+		case "BEGIN":
+			value = strings.ToUpper(value)
+			components = append(components, value)
+			if value == "VCALENDAR" {
+				foundCalendar = true
+			}
+			if value == "VEVENT" {
+				event = &ICalEvent{}
+				duration = 0
+			}
+			continue
+		case "END":
+			if len(components) == 0 || components[len(components)-1] != strings.ToUpper(value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%v", i+1, value)
+			}
+			components = components[:len(components)-1]
+			if strings.ToUpper(value) == "VEVENT" {
+				if event.End.IsZero() && !event.Start.IsZero() {
+					switch {
+					case duration != 0:
+						event.End = event.Start.Add(duration)
+					case event.AllDay:
+						event.End = event.Start.AddDate(0, 0, 1)
+					default:
+						event.End = event.Start
+					}
+				}
+				events = append(events, *event)
+				event = nil
+			}
+			continue
+		}
+		if event == nil || components[len(components)-1] != "VEVENT" {
+			continue
+		}
+		switch name {
+		case "UID":
+			event.UID = value
+		case "SUMMARY":
+			event.Summary = icalUnescape(value)
+		case "STATUS":
+			event.Status = strings.ToUpper(value)
+		case "DTSTART":
+			event.Start, event.AllDay, err = parseICalTime(value, params, location)
+		case "DTEND":
+			event.End, _, err = parseICalTime(value, params, location)
+		case "DURATION":
+			duration, err = parseICalDuration(value)
+		case "RECURRENCE-ID":
+			event.RecurrenceID, _, err = parseICalTime(value, params, location)
+		case "RRULE", "RDATE":
+			event.Recurring = true
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v: %w", i+1, name, err)
+		}
+	}
+	if !foundCalendar {
+		return nil, fmt.Errorf("not an iCalendar file")
+	}
+	if len(components) != 0 {
+		return nil, fmt.Errorf("unterminated %v", components[len(components)-1])
+	}
+	return events, nil
+}
+
+// unfoldICalLines joins the continuation lines, which start with a space or a tab.
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseICalLine splits a content line into the upper case name, the parameters and the value.
+func parseICalLine(line string) (string, map[string]string, string, error) {
+	params := map[string]string{}
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			parts := strings.Split(line[:i], ";")
+			for _, param := range parts[1:] {
+				key, val, _ := strings.Cut(param, "=")
+				params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+			}
+			return strings.ToUpper(parts[0]), params, line[i+1:], nil
+		}
+	}
+	return "", nil, "", fmt.Errorf("invalid content line %q", line)
+}
+
+// parseICalTime parses a DATE or DATE-TIME value and reports whether it was a date.
+func parseICalTime(value string, params map[string]string, location *time.Location) (time.Time, bool, error) {
+	if tzid := params["TZID"]; tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			location = tz
+		}
+	}
+	if params["VALUE"] == "DATE" || len(value) == len(icalDateLayout) {
+		t, err := time.ParseInLocation(icalDateLayout, value, location)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid date %q", value)
+		}
+		return t, true, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		location = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+	t, err := time.ParseInLocation(icalDateTimeLayout, value, location)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date and time %q", value)
+	}
+	return t, false, nil
+}
+
+var icalDurationRegexp = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func parseICalDuration(value string) (time.Duration, error) {
+	match := icalDurationRegexp.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{time.Hour * 24 * 7, time.Hour * 24, time.Hour, time.Minute, time.Second}
+	var result time.Duration
+	for i, unit := range units {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		result += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		result = -result
+	}
+	return result, nil
+}
+
+func icalUnescape(text string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(text)
+}
+
+// ICalImportChange is a single difference between the imported calendar and the stored schedule exceptions.
+type ICalImportChange struct {
+	// Exception is the exception to save, or the one to delete if Remove is set.
+	Exception ScheduleException
+	// Previous is the stored exception which is replaced, nil for new exceptions.
+	Previous *ScheduleException
+	Remove   bool
+}
+
+// ICalSkippedEvent is an upcoming event which could not be mapped onto the schedule.
+type ICalSkippedEvent struct {
+	Event  ICalEvent
+	Reason string
+}
+
+// ICalImportPlan is the result of reconciling an imported calendar with the schedule.
+type ICalImportPlan struct {
+	Changes   []ICalImportChange
+	Unchanged int
+	Skipped   []ICalSkippedEvent
+}
+
+// PlanICalImport maps the upcoming events onto schedule exceptions and compares them with the existing ones:
+// closures and cancelled events skip the nighthacks during them, nighthack events on a scheduled day move
+// that nighthack and nighthack events on other days are added as extra nighthacks.
+// Previously imported exceptions whose events are gone are removed, the ones created by hand are only replaced.
+func PlanICalImport(events []ICalEvent, schedule *ScheduleExpression, location *time.Location, existing []ScheduleException, now time.Time) *ICalImportPlan {
+	plan := &ICalImportPlan{}
+	horizon := now.Add(icalHorizon)
+	wanted := map[time.Time]ScheduleException{}
+	for _, event := range events {
+		if !event.Start.IsZero() && (!event.End.After(now) || event.Start.After(horizon)) {
+			continue
+		}
+		skip := func(reason string) {
+			plan.Skipped = append(plan.Skipped, ICalSkippedEvent{Event: event, Reason: reason})
+		}
+		switch {
+		case event.Start.IsZero():
+			skip("the event has no start")
+			continue
+		case event.Recurring:
+			skip("recurring events are not supported")
+			continue
+		}
+		summary := strings.ToLower(event.Summary)
+		isClosure := event.Status == "CANCELLED"
+		for _, keyword := range icalClosureKeywords {
+			isClosure = isClosure || strings.Contains(summary, keyword)
+		}
+		switch {
+		case isClosure:
+			occurrences := scheduleOccurrencesBetween(schedule, location, event.Start, event.End)
+			if len(occurrences) == 0 {
+				skip("no nighthack is scheduled during the event")
+			}
+			for _, occurrence := range occurrences {
+				wanted[occurrence] = ScheduleException{
+					Kind:         ScheduleExceptionCancel,
+					OccurrenceAt: occurrence,
+					StartsAt:     occurrence,
+					Summary:      event.Summary,
+					SourceUID:    event.Key(),
+				}
+			}
+		case strings.Contains(summary, "nighthack"):
+			if event.AllDay {
+				skip("all-day nighthacks have no start time")
+				continue
+			}
+			startsAt := event.Start.UTC()
+			local := event.Start.In(location)
+			dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+			occurrences := scheduleOccurrencesBetween(schedule, location, dayStart, dayStart.AddDate(0, 0, 1))
+			exception := ScheduleException{
+				Kind:         ScheduleExceptionExtra,
+				OccurrenceAt: startsAt,
+				StartsAt:     startsAt,
+				Summary:      event.Summary,
+				SourceUID:    event.Key(),
+			}
+			if len(occurrences) > 0 {
+				exception.Kind = ScheduleExceptionMove
+				exception.OccurrenceAt = occurrences[0]
+				for _, occurrence := range occurrences {
+					if occurrence.Equal(startsAt) {
+						exception.OccurrenceAt = occurrence
+					}
+				}
+			}
+			if exception.Kind == ScheduleExceptionMove && exception.OccurrenceAt.Equal(startsAt) {
+				// the nighthack takes place as scheduled
+				continue
+			}
+			// closures take precedence over nighthacks on the same day
+			if previous, ok := wanted[exception.OccurrenceAt]; ok && previous.Kind == ScheduleExceptionCancel {
+				continue
+			}
+			wanted[exception.OccurrenceAt] = exception
+		default:
+			skip("neither a nighthack nor a closure")
+		}
+	}
+
+	existingByOccurrence := map[time.Time]*ScheduleException{}
+	for i := range existing {
+		existingByOccurrence[existing[i].OccurrenceAt.UTC()] = &existing[i]
+	}
+	for occurrence, exception := range wanted {
+		previous := existingByOccurrence[occurrence]
+		if previous == nil {
+			plan.Changes = append(plan.Changes, ICalImportChange{Exception: exception})
+			continue
+		}
+		if previous.Kind == exception.Kind && previous.StartsAt.Equal(exception.StartsAt) &&
+			previous.Summary == exception.Summary && previous.SourceUID == exception.SourceUID {
+			plan.Unchanged++
+			continue
+		}
+		plan.Changes = append(plan.Changes, ICalImportChange{Exception: exception, Previous: previous})
+	}
+	for i := range existing {
+		previous := &existing[i]
+		if previous.SourceUID == "" || previous.StartsAt.After(horizon) {
+			continue
+		}
+		if !previous.StartsAt.After(now) && !previous.OccurrenceAt.After(now) {
+			continue
+		}
+		if _, ok := wanted[previous.OccurrenceAt.UTC()]; !ok {
+			plan.Changes = append(plan.Changes, ICalImportChange{Exception: *previous, Previous: previous, Remove: true})
+		}
+	}
+	sort.Slice(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].Exception.OccurrenceAt.Before(plan.Changes[j].Exception.OccurrenceAt)
+	})
+	return plan
+}
+
+// scheduleOccurrencesBetween returns the occurrences of the schedule in [from, to) in UTC.
+func scheduleOccurrencesBetween(schedule *ScheduleExpression, location *time.Location, from time.Time, to time.Time) []time.Time {
+	if schedule == nil {
+		return nil
+	}
+	result := []time.Time{}
+	for _, occurrence := range schedule.Occurrences(from.Add(-time.Nanosecond).In(location), to) {
+		result = append(result, occurrence.UTC())
+	}
+	return result
+}
@@ -0,0 +1,144 @@
+package nighthackbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICal = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:christmas@wiki\r\n" +
+	"SUMMARY:Space closed\\, Christmas\r\n" +
+	"DTSTART;VALUE=DATE:20261224\r\n" +
+	"DTEND;VALUE=DATE:20261227\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:special@wiki\r\n" +
+	"SUMMARY:Nighthack special\r\n" +
+	"DTSTART;TZID=Europe/Warsaw:20261106T200000\r\n" +
+	"DURATION:PT4H\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"TRIGGER:-PT1H\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:extra@wiki\r\n" +
+	"SUMMARY:Extra nighthack\r\n" +
+	"DTSTART:20261110T170000Z\r\n" +
+	"DTEND:20261110T230000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meetup@wiki\r\n" +
+	"SUMMARY:Monthly meetup with a very long title which has to be folded over sev\r\n" +
+	" eral lines\r\n" +
+	"DTSTART:20261112T170000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:weekly@wiki\r\n" +
+	"SUMMARY:Nighthack\r\n" +
+	"DTSTART:20261101T170000Z\r\n" +
+	"RRULE:FREQ=WEEKLY\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:past@wiki\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"DTSTART;VALUE=DATE:20250101\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICal(t *testing.T) {
+	warsaw, _ := time.LoadLocation("Europe/Warsaw")
+	events, err := ParseICal(strings.NewReader(testICal), warsaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+	closure := events[0]
+	if closure.Summary != "Space closed, Christmas" || !closure.AllDay {
+		t.Errorf("unexpected closure %+v", closure)
+	}
+	if !closure.Start.Equal(time.Date(2026, 12, 24, 0, 0, 0, 0, warsaw)) || !closure.End.Equal(time.Date(2026, 12, 27, 0, 0, 0, 0, warsaw)) {
+		t.Errorf("unexpected closure time %v - %v", closure.Start, closure.End)
+	}
+	special := events[1]
+	if special.Start.UTC().Format(time.RFC3339) != "2026-11-06T19:00:00Z" || special.End.Sub(special.Start) != time.Hour*4 {
+		t.Errorf("unexpected special nighthack time %v - %v", special.Start, special.End)
+	}
+	if !strings.HasSuffix(events[3].Summary, "over several lines") {
+		t.Errorf("expected unfolded summary, got %q", events[3].Summary)
+	}
+	if !events[4].Recurring {
+		t.Errorf("expected recurring event")
+	}
+
+	if _, err := ParseICal(strings.NewReader("hello"), warsaw); err == nil {
+		t.Errorf("expected error for a file which is not a calendar")
+	}
+	if _, err := ParseICal(strings.NewReader("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\n"), warsaw); err == nil {
+		t.Errorf("expected error for an unterminated calendar")
+	}
+}
+
+func TestPlanICalImport(t *testing.T) {
+	warsaw, _ := time.LoadLocation("Europe/Warsaw")
+	schedule, err := ParseScheduleExpression("friday 18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := ParseICal(strings.NewReader(testICal), warsaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, _ := time.Parse(time.RFC3339, "2026-11-01T12:00:00Z")
+	previouslyImported := ScheduleException{
+		Kind:         ScheduleExceptionCancel,
+		OccurrenceAt: time.Date(2026, 11, 20, 18, 0, 0, 0, warsaw).UTC(),
+		StartsAt:     time.Date(2026, 11, 20, 18, 0, 0, 0, warsaw).UTC(),
+		SourceUID:    "deleted@wiki",
+	}
+	manual := ScheduleException{
+		Kind:         ScheduleExceptionMove,
+		OccurrenceAt: time.Date(2026, 11, 27, 18, 0, 0, 0, warsaw).UTC(),
+		StartsAt:     time.Date(2026, 11, 28, 18, 0, 0, 0, warsaw).UTC(),
+	}
+	plan := PlanICalImport(events, schedule, warsaw, []ScheduleException{previouslyImported, manual}, now)
+
+	got := []string{}
+	for _, change := range plan.Changes {
+		action := "add"
+		if change.Remove {
+			action = "remove"
+		} else if change.Previous != nil {
+			action = "update"
+		}
+		got = append(got, action+" "+string(change.Exception.Kind)+" "+change.Exception.OccurrenceAt.Format(time.RFC3339)+" "+change.Exception.StartsAt.Format(time.RFC3339))
+	}
+	expected := []string{
+		"add move 2026-11-06T17:00:00Z 2026-11-06T19:00:00Z",
+		"add extra 2026-11-10T17:00:00Z 2026-11-10T17:00:00Z",
+		"remove cancel 2026-11-20T17:00:00Z 2026-11-20T17:00:00Z",
+		"add cancel 2026-12-25T17:00:00Z 2026-12-25T17:00:00Z",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+	if len(plan.Skipped) != 2 {
+		t.Errorf("expected the meetup and the recurring event to be skipped, got %+v", plan.Skipped)
+	}
+
+	// importing the same calendar again changes nothing
+	applied := []ScheduleException{manual}
+	for _, change := range plan.Changes {
+		if !change.Remove {
+			applied = append(applied, change.Exception)
+		}
+	}
+	plan = PlanICalImport(events, schedule, warsaw, applied, now)
+	if len(plan.Changes) != 0 || plan.Unchanged != 3 {
+		t.Errorf("expected no changes on reimport, got %+v", plan)
+	}
+}
@@ -0,0 +1,128 @@
+package nighthackbot
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alufers/nighthack-bot/pkg/faketg"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// TestRunAgainstFakeTelegram runs the whole bot, from InitTelegram to the update loop, against a fake Bot API.
+func TestRunAgainstFakeTelegram(t *testing.T) {
+	server := faketg.NewServer()
+	defer server.Close()
+
+	admin := tgbotapi.User{ID: 42, UserName: "root"}
+	bob := tgbotapi.User{ID: 43, UserName: "bob"}
+	adminChat := tgbotapi.Chat{ID: admin.ID, Type: "private"}
+	bobChat := tgbotapi.Chat{ID: bob.ID, Type: "private"}
+	hasText := func(substring string) func(msg tgbotapi.Message) bool {
+		return func(msg tgbotapi.Message) bool {
+			return strings.Contains(msg.Text, substring)
+		}
+	}
+	const timeout = 10 * time.Second
+
+	app := NewBotApp()
+	app.Config.Telegram.Token = server.Token
+	app.Config.Telegram.APIEndpoint = server.Endpoint()
+	app.Config.DB.Type = "sqlite"
+	app.Config.DB.Filename = filepath.Join(t.TempDir(), "test.db")
+	app.Config.Admins = []int64{admin.ID}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if err := app.Start(ctx); err != nil {
+			t.Errorf("failed to start: %v", err)
+		}
+	}()
+
+	// the admin from the config sees the admin commands
+	server.SendMessage(adminChat, admin, "/start", nil)
+	welcome, err := server.WaitForBotMessage(adminChat.ID, timeout, hasText("Welcome to @"+server.Bot.UserName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(welcome.Text, "/admin") {
+		t.Errorf("expected the admin commands to be listed, got %q", welcome.Text)
+	}
+	if len(server.Commands()) == 0 {
+		t.Errorf("expected the commands to be set")
+	}
+
+	server.SendMessage(bobChat, bob, "/start", nil)
+	if _, err := server.WaitForBotMessage(bobChat.ID, timeout, hasText("Welcome")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a conversation: the admin is asked for the new admin and answers with a reply
+	server.SendMessage(adminChat, admin, "/admin add_admin_user", nil)
+	question, err := server.WaitForBotMessage(adminChat.ID, timeout, hasText("Who should be the new admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the question is registered right after it is sent
+	waitForQuestion(t, app, admin.ID, FakeMessage{ChatID: adminChat.ID, MessageID: question.MessageID, Text: question.Text})
+	server.SendMessage(adminChat, admin, "@bob", &question)
+	if _, err := server.WaitForBotMessage(adminChat.ID, timeout, hasText("is now an admin")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitForBotMessage(bobChat.ID, timeout, hasText("made you an admin")); err != nil {
+		t.Fatal(err)
+	}
+	if !server.IsDeleted(question) {
+		t.Errorf("expected the question to be deleted after the answer")
+	}
+
+	// buttons are answered
+	menu, err := func() (tgbotapi.Message, error) {
+		server.SendMessage(adminChat, admin, "/admin", nil)
+		return server.WaitForBotMessage(adminChat.ID, timeout, hasText("Admin options"))
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if menu.ReplyMarkup == nil {
+		t.Fatalf("expected the admin menu to have buttons")
+	}
+	callbackID := server.PressButton(admin, menu, "null")
+	if _, err := server.WaitForCallbackAnswer(callbackID, timeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// stopping the bot interrupts the question, which is kept to be asked again after the restart
+	server.SendMessage(adminChat, admin, "/admin remove_admin_user", nil)
+	question, err = server.WaitForBotMessage(adminChat.ID, timeout, hasText("Select admin to remove"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, admin.ID, FakeMessage{ChatID: adminChat.ID, MessageID: question.MessageID, Text: question.Text})
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		t.Fatalf("the bot did not stop")
+	}
+	db, err := gorm.Open(sqlite.Open(app.Config.DB.Filename), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := []PendingQuestion{}
+	if err := db.Find(&pending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].CommandText != "/admin remove_admin_user" {
+		t.Errorf("expected the interrupted question to be kept, got %+v", pending)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
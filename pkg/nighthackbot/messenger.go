@@ -0,0 +1,59 @@
+package nighthackbot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messenger is how the bot talks to the users. The services and commands only use this interface,
+// so it can be replaced, for example by FakeMessenger in tests.
+type Messenger interface {
+	// Send sends a new message, like a tgbotapi.MessageConfig or a tgbotapi.DocumentConfig.
+	Send(msg tgbotapi.Chattable) (tgbotapi.Message, error)
+	// Edit changes an already sent message, like a tgbotapi.EditMessageTextConfig.
+	Edit(edit tgbotapi.Chattable) error
+	Delete(chatID int64, messageID int) error
+	// AnswerCallback stops the loading animation of a button, showing the text as a notification if it is not empty.
+	AnswerCallback(callbackID string, text string) error
+	SetCommands(commands []tgbotapi.BotCommand) error
+	// FileURL returns the address a file sent to the bot can be downloaded from.
+	FileURL(fileID string) (string, error)
+}
+
+// TelegramMessenger sends the messages with the Telegram Bot API.
+type TelegramMessenger struct {
+	Bot *tgbotapi.BotAPI
+}
+
+func NewTelegramMessenger(bot *tgbotapi.BotAPI) *TelegramMessenger {
+	return &TelegramMessenger{
+		Bot: bot,
+	}
+}
+
+func (m *TelegramMessenger) Send(msg tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return m.Bot.Send(msg)
+}
+
+func (m *TelegramMessenger) Edit(edit tgbotapi.Chattable) error {
+	_, err := m.Bot.Request(edit)
+	return err
+}
+
+func (m *TelegramMessenger) Delete(chatID int64, messageID int) error {
+	_, err := m.Bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
+
+func (m *TelegramMessenger) AnswerCallback(callbackID string, text string) error {
+	_, err := m.Bot.Request(tgbotapi.NewCallback(callbackID, text))
+	return err
+}
+
+func (m *TelegramMessenger) SetCommands(commands []tgbotapi.BotCommand) error {
+	_, err := m.Bot.Request(tgbotapi.NewSetMyCommands(commands...))
+	return err
+}
+
+func (m *TelegramMessenger) FileURL(fileID string) (string, error) {
+	return m.Bot.GetFileDirectURL(fileID)
+}
@@ -0,0 +1,198 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type FakeMessageKind string
+
+const (
+	FakeMessageSent     FakeMessageKind = "send"
+	FakeMessageEdited   FakeMessageKind = "edit"
+	FakeMessageDeleted  FakeMessageKind = "delete"
+	FakeMessageCallback FakeMessageKind = "callback"
+)
+
+// FakeMessage is a call to a FakeMessenger.
+type FakeMessage struct {
+	Kind      FakeMessageKind
+	ChatID    int64
+	MessageID int
+	// Text is the text of a message, the caption of a document or the notification of a callback answer.
+	Text       string
+	CallbackID string
+	// Chattable is the sent or edit config, nil for deletes and callback answers.
+	Chattable tgbotapi.Chattable
+}
+
+// ReplyMarkup returns the keyboard of a sent message, nil if it has none.
+func (m FakeMessage) ReplyMarkup() interface{} {
+	switch c := m.Chattable.(type) {
+	case tgbotapi.MessageConfig:
+		return c.ReplyMarkup
+	case tgbotapi.DocumentConfig:
+		return c.ReplyMarkup
+	case tgbotapi.EditMessageTextConfig:
+		if c.ReplyMarkup != nil {
+			return *c.ReplyMarkup
+		}
+	}
+	return nil
+}
+
+// FakeMessenger is an in-memory Messenger which records everything the bot sends.
+type FakeMessenger struct {
+	mutex         sync.Mutex
+	messages      []FakeMessage
+	commands      []tgbotapi.BotCommand
+	nextMessageID int
+	// changed is closed and replaced whenever a message is recorded
+	changed chan struct{}
+	// SendError makes Send fail for the messages it returns an error for, it must be set before the messenger is used
+	SendError func(msg FakeMessage) error
+	// EditError makes Edit fail like SendError does for Send
+	EditError func(msg FakeMessage) error
+	// Files are the URLs returned by FileURL by file ID, it must be set before the messenger is used
+	Files map[string]string
+}
+
+func NewFakeMessenger() *FakeMessenger {
+	return &FakeMessenger{
+		nextMessageID: 1000,
+		changed:       make(chan struct{}),
+	}
+}
+
+func (m *FakeMessenger) record(msg FakeMessage) FakeMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if msg.Kind == FakeMessageSent {
+		m.nextMessageID++
+		msg.MessageID = m.nextMessageID
+	}
+	m.messages = append(m.messages, msg)
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return msg
+}
+
+func (m *FakeMessenger) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg := FakeMessage{Kind: FakeMessageSent, Chattable: c}
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		msg.ChatID = c.ChatID
+		msg.Text = c.Text
+	case tgbotapi.DocumentConfig:
+		msg.ChatID = c.ChatID
+		msg.Text = c.Caption
+	default:
+		return tgbotapi.Message{}, fmt.Errorf("FakeMessenger cannot send %T", c)
+	}
+	if m.SendError != nil {
+		if err := m.SendError(msg); err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+	msg = m.record(msg)
+	return tgbotapi.Message{
+		MessageID: msg.MessageID,
+		Chat:      &tgbotapi.Chat{ID: msg.ChatID},
+		Text:      msg.Text,
+		Date:      int(time.Now().Unix()),
+	}, nil
+}
+
+func (m *FakeMessenger) Edit(c tgbotapi.Chattable) error {
+	msg := FakeMessage{Kind: FakeMessageEdited, Chattable: c}
+	switch c := c.(type) {
+	case tgbotapi.EditMessageTextConfig:
+		msg.ChatID = c.ChatID
+		msg.MessageID = c.MessageID
+		msg.Text = c.Text
+	default:
+		return fmt.Errorf("FakeMessenger cannot edit with %T", c)
+	}
+	if m.EditError != nil {
+		if err := m.EditError(msg); err != nil {
+			return err
+		}
+	}
+	m.record(msg)
+	return nil
+}
+
+func (m *FakeMessenger) Delete(chatID int64, messageID int) error {
+	m.record(FakeMessage{Kind: FakeMessageDeleted, ChatID: chatID, MessageID: messageID})
+	return nil
+}
+
+func (m *FakeMessenger) AnswerCallback(callbackID string, text string) error {
+	m.record(FakeMessage{Kind: FakeMessageCallback, CallbackID: callbackID, Text: text})
+	return nil
+}
+
+func (m *FakeMessenger) SetCommands(commands []tgbotapi.BotCommand) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.commands = commands
+	return nil
+}
+
+func (m *FakeMessenger) FileURL(fileID string) (string, error) {
+	if url, ok := m.Files[fileID]; ok {
+		return url, nil
+	}
+	return "", fmt.Errorf("FakeMessenger has no file %q", fileID)
+}
+
+// Messages returns everything recorded so far.
+func (m *FakeMessenger) Messages() []FakeMessage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]FakeMessage{}, m.messages...)
+}
+
+// Sent returns the messages sent to the chat.
+func (m *FakeMessenger) Sent(chatID int64) []FakeMessage {
+	sent := []FakeMessage{}
+	for _, msg := range m.Messages() {
+		if msg.Kind == FakeMessageSent && msg.ChatID == chatID {
+			sent = append(sent, msg)
+		}
+	}
+	return sent
+}
+
+// Commands returns the commands set with SetCommands.
+func (m *FakeMessenger) Commands() []tgbotapi.BotCommand {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.commands
+}
+
+// WaitFor waits until a message matching the function has been recorded after the first skip ones.
+// It returns the index of the message, so the next call can continue after it.
+func (m *FakeMessenger) WaitFor(skip int, timeout time.Duration, match func(msg FakeMessage) bool) (int, FakeMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mutex.Lock()
+		for i := skip; i < len(m.messages); i++ {
+			if match(m.messages[i]) {
+				msg := m.messages[i]
+				m.mutex.Unlock()
+				return i, msg, nil
+			}
+		}
+		changed := m.changed
+		m.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return 0, FakeMessage{}, fmt.Errorf("timed out after %v waiting for a message", timeout)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package nighthackbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrRateLimited      = errors.New("too many commands, slow down")
+)
+
+// CommandHandler executes a command, it is the type wrapped by the middleware.
+type CommandHandler func(ctx context.Context, args *CommandArguments) error
+
+// Middleware wraps the execution of every command, for example to check permissions.
+type Middleware func(next CommandHandler) CommandHandler
+
+// chainMiddleware wraps the handler so that the first middleware is the outermost one.
+func chainMiddleware(handler CommandHandler, middleware ...Middleware) CommandHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// executeCommand is the innermost handler of the chain.
+func executeCommand(ctx context.Context, args *CommandArguments) error {
+	return args.Command.Execute(ctx, args)
+}
+
+// LoggingMiddleware logs every executed command with its duration and result.
+func LoggingMiddleware() Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, args *CommandArguments) error {
+			start := time.Now()
+			err := next(ctx, args)
+			event := log.Info()
+			if err != nil {
+				event = log.Warn().Err(err)
+			}
+			event.
+				Str("command", args.CommandName).
+				Int64("user_id", args.FromUserID).
+				Int64("chat_id", args.ChatID).
+				Dur("duration", time.Since(start)).
+				Msgf("Executed command")
+			return err
+		}
+	}
+}
+
+// RecoverMiddleware turns a panic in a command into an error, so a single broken command does not take the bot down.
+func RecoverMiddleware() Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, args *CommandArguments) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error().
+						Str("command", args.CommandName).
+						Interface("panic", r).
+						Bytes("stack", debug.Stack()).
+						Msgf("Command panicked")
+					err = fmt.Errorf("internal error while executing %v", args.CommandName)
+				}
+			}()
+			return next(ctx, args)
+		}
+	}
+}
+
+// RateLimitMiddleware rejects the commands of users who exceed the limiter.
+func RateLimitMiddleware(limiter *RateLimiter) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, args *CommandArguments) error {
+			if !limiter.Allow(args.FromUserID) {
+				return ErrRateLimited
+			}
+			return next(ctx, args)
+		}
+	}
+}
+
+// PermissionMiddleware enforces the requirements of restricted commands and records the denials in the audit log.
+func PermissionMiddleware(app *BotApp) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, args *CommandArguments) error {
+			if err := CommandRequirementsOf(args.Command).Check(args.User, args.ChatType); err != nil {
+				app.AuditService.RecordCommand(args, AuditActionPermissionDenied, err.Error())
+				return fmt.Errorf("%w: %v %v", ErrPermissionDenied, args.CommandName, err)
+			}
+			return next(ctx, args)
+		}
+	}
+}
+
+// RateLimiter is a token bucket per user: every user can run Burst commands at once,
+// and gets another one every Interval.
+type RateLimiter struct {
+	Burst    int
+	Interval time.Duration
+
+	mutex   sync.Mutex
+	buckets map[int64]*rateBucket
+	now     func() time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxRateBuckets is the number of tracked users after which the full buckets are forgotten
+const maxRateBuckets = 1000
+
+func NewRateLimiter(burst int, interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Burst:    burst,
+		Interval: interval,
+		buckets:  map[int64]*rateBucket{},
+		now:      time.Now,
+	}
+}
+
+// Allow takes a token of the user and reports whether there was one.
+func (l *RateLimiter) Allow(userID int64) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	if len(l.buckets) >= maxRateBuckets {
+		l.pruneLocked(now)
+	}
+	bucket, ok := l.buckets[userID]
+	if !ok {
+		bucket = &rateBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[userID] = bucket
+	}
+	l.refillLocked(bucket, now)
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (l *RateLimiter) refillLocked(bucket *rateBucket, now time.Time) {
+	if l.Interval > 0 {
+		bucket.tokens += float64(now.Sub(bucket.last)) / float64(l.Interval)
+	} else {
+		bucket.tokens = float64(l.Burst)
+	}
+	if bucket.tokens > float64(l.Burst) {
+		bucket.tokens = float64(l.Burst)
+	}
+	bucket.last = now
+}
+
+// pruneLocked forgets the users whose buckets have refilled, they behave the same as new users.
+func (l *RateLimiter) pruneLocked(now time.Time) {
+	for userID, bucket := range l.buckets {
+		l.refillLocked(bucket, now)
+		if bucket.tokens >= float64(l.Burst) {
+			delete(l.buckets, userID)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package nighthackbot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChainMiddleware(t *testing.T) {
+	calls := []string{}
+	record := func(name string) Middleware {
+		return func(next CommandHandler) CommandHandler {
+			return func(ctx context.Context, args *CommandArguments) error {
+				calls = append(calls, name)
+				return next(ctx, args)
+			}
+		}
+	}
+	handler := chainMiddleware(func(ctx context.Context, args *CommandArguments) error {
+		calls = append(calls, "command")
+		return nil
+	}, record("first"), record("second"))
+	if err := handler(context.Background(), &CommandArguments{}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"first", "second", "command"}; !reflect.DeepEqual(calls, expected) {
+		t.Errorf("expected %v, got %v", expected, calls)
+	}
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	handler := RecoverMiddleware()(func(ctx context.Context, args *CommandArguments) error {
+		panic("broken")
+	})
+	if err := handler(context.Background(), &CommandArguments{CommandName: "/broken"}); err == nil {
+		t.Errorf("expected the panic to be returned as an error")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(3, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(1) {
+			t.Fatalf("command %d of the burst was rejected", i)
+		}
+	}
+	if limiter.Allow(1) {
+		t.Errorf("expected the command after the burst to be rejected")
+	}
+	if !limiter.Allow(2) {
+		t.Errorf("expected other users not to be limited")
+	}
+	now = now.Add(time.Minute)
+	if !limiter.Allow(1) {
+		t.Errorf("expected a token after the interval")
+	}
+	if limiter.Allow(1) {
+		t.Errorf("expected only one token after the interval")
+	}
+}
+
+func TestCommandRequirementsCheck(t *testing.T) {
+	admin := &User{IsAdmin: true}
+	keyholder := &User{IsMember: true, IsKeyholder: true}
+	member := &User{IsMember: true}
+	tests := []struct {
+		requirements CommandRequirements
+		user         *User
+		chatType     string
+		allowed      bool
+	}{
+		{CommandRequirements{}, nil, "group", true},
+		{CommandRequirements{}, &User{}, "private", true},
+		{CommandRequirements{Role: RoleAdmin}, admin, "group", true},
+		{CommandRequirements{Role: RoleAdmin}, keyholder, "group", false},
+		{CommandRequirements{Role: RoleKeyholder}, admin, "group", true},
+		{CommandRequirements{Role: RoleKeyholder}, member, "group", false},
+		{CommandRequirements{Role: RoleMember}, &User{}, "private", false},
+		{CommandRequirements{ChatTypes: []string{"private"}}, admin, "private", true},
+		{CommandRequirements{ChatTypes: []string{"private"}}, admin, "supergroup", false},
+	}
+	for i, test := range tests {
+		err := test.requirements.Check(test.user, test.chatType)
+		if (err == nil) != test.allowed {
+			t.Errorf("%d: expected allowed=%v, got %v", i, test.allowed, err)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := RateLimitMiddleware(NewRateLimiter(1, time.Hour))(func(ctx context.Context, args *CommandArguments) error {
+		return nil
+	})
+	args := &CommandArguments{FromUserID: 1}
+	if err := handler(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(context.Background(), args); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
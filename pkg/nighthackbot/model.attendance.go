@@ -0,0 +1,20 @@
+package nighthackbot
+
+import (
+	"time"
+
+	"github.com/alufers/nighthack-bot/dbutil"
+)
+
+// Attendance is a stay of a user in the space during a nighthack, from checking in to checking out.
+type Attendance struct {
+	dbutil.Model
+	NighthackID  string     `gorm:"index" json:"nighthackID"`
+	Nighthack    *Nighthack `json:"nighthack,omitempty"`
+	UserID       string     `gorm:"index" json:"userID"`
+	User         *User      `json:"user,omitempty"`
+	CheckedInAt  time.Time  `json:"checkedInAt"`
+	CheckedOutAt *time.Time `json:"checkedOutAt"` // nil while the user is in the space
+	// AutoClosed means the user did not check out and the stay was closed at the end of the nighthack.
+	AutoClosed bool `json:"autoClosed"`
+}
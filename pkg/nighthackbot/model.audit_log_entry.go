@@ -0,0 +1,26 @@
+package nighthackbot
+
+import "github.com/alufers/nighthack-bot/dbutil"
+
+type AuditAction string
+
+const (
+	AuditActionPermissionDenied AuditAction = "permission_denied"
+	AuditActionRoleChanged      AuditAction = "role_changed"
+	AuditActionAdminGranted     AuditAction = "admin_granted"
+	AuditActionAdminRevoked     AuditAction = "admin_revoked"
+	AuditActionKeyAdded         AuditAction = "key_added"
+	AuditActionKeyAssigned      AuditAction = "key_assigned"
+	AuditActionKeyReturned      AuditAction = "key_returned"
+)
+
+// AuditLogEntry records a security relevant event, like a denied command or a changed role.
+type AuditLogEntry struct {
+	dbutil.Model
+	Action     AuditAction `gorm:"index" json:"action"`
+	UserID     *string     `json:"userID"` // the user who caused the event, nil if unknown
+	TelegramID int64       `json:"telegramID"`
+	ChatID     int64       `json:"chatID"`
+	Command    string      `json:"command"`
+	Details    string      `json:"details"`
+}
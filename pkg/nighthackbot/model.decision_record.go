@@ -0,0 +1,29 @@
+package nighthackbot
+
+import "github.com/alufers/nighthack-bot/dbutil"
+
+type NighthackDecision string
+
+const (
+	NighthackDecisionOn        NighthackDecision = "on"
+	NighthackDecisionCancelled NighthackDecision = "cancelled"
+)
+
+type DecisionSource string
+
+const (
+	DecisionSourceQuorum DecisionSource = "quorum"
+	DecisionSourceAdmin  DecisionSource = "admin"
+)
+
+// DecisionRecord is an audit log entry of a go/no-go decision about a nighthack.
+type DecisionRecord struct {
+	dbutil.Model
+	NighthackID string            `gorm:"index" json:"nighthackID"`
+	Decision    NighthackDecision `json:"decision"`
+	Source      DecisionSource    `json:"source"`
+	Reason      string            `json:"reason"`
+	Keyholders  int               `json:"keyholders"`
+	Attendees   int               `json:"attendees"`
+	UserID      *string           `json:"userID"` // the admin who forced the decision
+}
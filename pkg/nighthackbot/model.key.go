@@ -0,0 +1,44 @@
+package nighthackbot
+
+import (
+	"time"
+
+	"github.com/alufers/nighthack-bot/dbutil"
+)
+
+type KeyKind string
+
+const (
+	KeyKindKey  KeyKind = "key"
+	KeyKindCard KeyKind = "card"
+)
+
+// KeyKindLabels are the icons and names of the kinds of keys.
+var KeyKindLabels = []struct {
+	Kind KeyKind
+	Icon string
+	Name string
+}{
+	{KeyKindKey, "🔑", "Key"},
+	{KeyKindCard, "💳", "RFID card"},
+}
+
+// Key is a physical key or an RFID card which opens the space. Users holding one are keyholders.
+type Key struct {
+	dbutil.Model
+	Kind     KeyKind `json:"kind"`
+	Label    string  `gorm:"uniqueindex" json:"label"` // for example "front door 2"
+	HolderID *string `gorm:"index" json:"holderID"`    // nil if the key is in the space
+	Holder   *User   `json:"holder,omitempty"`
+	// HeldSince is when the key was given to the current holder.
+	HeldSince *time.Time `json:"heldSince"`
+}
+
+func (k *Key) String() string {
+	for _, label := range KeyKindLabels {
+		if label.Kind == k.Kind {
+			return label.Icon + " " + k.Label
+		}
+	}
+	return k.Label
+}
@@ -0,0 +1,35 @@
+package nighthackbot
+
+import (
+	"time"
+
+	"github.com/alufers/nighthack-bot/dbutil"
+)
+
+// Nighthack is a single instance of the recurring nighthack.
+type Nighthack struct {
+	dbutil.Model
+	// OccurrenceAt is the occurrence of the schedule this instance was created for.
+	OccurrenceAt            time.Time  `gorm:"uniqueindex" json:"occurrenceAt"`
+	StartsAt                time.Time  `gorm:"index" json:"startsAt"`
+	CallForVolunteersAt     time.Time  `json:"callForVolunteersAt"`
+	CallForVolunteersSentAt *time.Time `json:"callForVolunteersSentAt"`
+	CallMessageChatID       int64      `json:"callMessageChatID"`
+	CallMessageID           int        `json:"callMessageID"`
+	AnnouncedAt             *time.Time `json:"announcedAt"`
+	// the announcement message keeps the list of the users in the space
+	AnnouncementChatID    int64 `json:"announcementChatID"`
+	AnnouncementMessageID int   `json:"announcementMessageID"`
+	// ForcedDecision overrides the quorum for this instance only.
+	ForcedDecision NighthackDecision `json:"forcedDecision"`
+	Decision       NighthackDecision `json:"decision"`
+	DecidedAt      *time.Time        `json:"decidedAt"`
+}
+
+// EffectiveDecision returns the forced decision if there is one, otherwise the decision of the quorum.
+func (n *Nighthack) EffectiveDecision() NighthackDecision {
+	if n.ForcedDecision != "" {
+		return n.ForcedDecision
+	}
+	return n.Decision
+}
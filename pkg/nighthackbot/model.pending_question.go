@@ -0,0 +1,31 @@
+package nighthackbot
+
+import "github.com/alufers/nighthack-bot/dbutil"
+
+type PendingQuestionKind string
+
+const (
+	PendingQuestionArgument PendingQuestionKind = "argument"
+	PendingQuestionConfirm  PendingQuestionKind = "confirm"
+)
+
+// PendingQuestion is a question of a command which waits for an answer.
+// It keeps the command with the answers given so far, so the conversation can be resumed after a restart.
+type PendingQuestion struct {
+	dbutil.Model
+	ChatID         int64  `gorm:"index" json:"chatID"`
+	ChatType       string `json:"chatType"`
+	UserTelegramID int64  `json:"userTelegramID"`
+	UserName       string `json:"userName"`
+	CommandText    string `json:"commandText"` // the command which asked the question
+	// CommandMessageID is the message with the command, zero if it was a button
+	CommandMessageID int `json:"commandMessageID"`
+	// CallbackMessageID is the message with the button which executed the command, zero if it was a message
+	CallbackMessageID int `json:"callbackMessageID"`
+	// ReplaySafe commands are executed again after a restart, the others are dropped
+	ReplaySafe bool                `json:"replaySafe"`
+	Kind       PendingQuestionKind `json:"kind"`
+	Question   string              `json:"question"`
+	MessageID  int                 `json:"messageID"` // the message with the question
+	Answers    string              `json:"answers"`   // JSON list of the previous answers of the conversation
+}
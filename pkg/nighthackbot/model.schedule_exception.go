@@ -0,0 +1,32 @@
+package nighthackbot
+
+import (
+	"time"
+
+	"github.com/alufers/nighthack-bot/dbutil"
+)
+
+type ScheduleExceptionKind string
+
+const (
+	// ScheduleExceptionMove moves a single occurrence of the schedule to StartsAt.
+	ScheduleExceptionMove ScheduleExceptionKind = "move"
+	// ScheduleExceptionCancel skips a single occurrence of the schedule, for example on holidays.
+	ScheduleExceptionCancel ScheduleExceptionKind = "cancel"
+	// ScheduleExceptionExtra adds a one-off nighthack outside of the schedule, OccurrenceAt is its original start.
+	ScheduleExceptionExtra ScheduleExceptionKind = "extra"
+)
+
+// ScheduleException changes a single occurrence of the nighthack schedule without changing the recurring rule.
+type ScheduleException struct {
+	dbutil.Model
+	Kind ScheduleExceptionKind `json:"kind"`
+	// OccurrenceAt is the occurrence of the recurring schedule which is affected.
+	OccurrenceAt time.Time `gorm:"index" json:"occurrenceAt"`
+	StartsAt     time.Time `gorm:"index" json:"startsAt"`
+	UserID       *string   `json:"userID"` // the admin who created the exception
+	// Summary describes the exception, for example the name of the imported calendar event.
+	Summary string `json:"summary"`
+	// SourceUID is the UID of the calendar event the exception was imported from, empty if created by hand.
+	SourceUID string `gorm:"index" json:"sourceUID"`
+}
@@ -1,12 +1,48 @@
 package nighthackbot
 
-import "github.com/alufers/nighthack-bot/dbutil"
+import (
+	"strconv"
+
+	"github.com/alufers/nighthack-bot/dbutil"
+)
 
 type User struct {
 	dbutil.Model
-	TelegramID          int64   `gorm:"uniqueindex" json:"telegramID"`
-	Username            string  `json:"username"`
-	Email               *string `json:"email"`
-	IsAdmin             bool    `json:"isAdmin"`
-	PingAboutNighthacks bool    `json:"pingAboutNighthacks"`
+	TelegramID int64   `gorm:"uniqueindex" json:"telegramID"`
+	Username   string  `json:"username"`
+	Email      *string `json:"email"`
+	IsAdmin    bool    `json:"isAdmin"`
+	// AdminSource is where IsAdmin was last changed: config, /admin or cli
+	AdminSource         string `json:"adminSource"`
+	IsMember            bool   `json:"isMember"`
+	IsKeyholder         bool   `json:"isKeyholder"`
+	PingAboutNighthacks bool   `json:"pingAboutNighthacks"`
+}
+
+// DisplayName returns the @username of the user or their telegram ID if the username is unknown.
+func (u *User) DisplayName() string {
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	return strconv.FormatInt(u.TelegramID, 10)
+}
+
+// Role returns the highest role of the user.
+func (u *User) Role() Role {
+	switch {
+	case u == nil:
+		return RoleAnyone
+	case u.IsAdmin:
+		return RoleAdmin
+	case u.IsKeyholder:
+		return RoleKeyholder
+	case u.IsMember:
+		return RoleMember
+	}
+	return RoleAnyone
+}
+
+// HasRole reports whether the user has the role or a higher one.
+func (u *User) HasRole(role Role) bool {
+	return u.Role() >= role
 }
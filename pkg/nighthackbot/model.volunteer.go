@@ -0,0 +1,21 @@
+package nighthackbot
+
+import "github.com/alufers/nighthack-bot/dbutil"
+
+type VolunteerStatus string
+
+const (
+	VolunteerStatusOpen   VolunteerStatus = "open"
+	VolunteerStatusAttend VolunteerStatus = "attend"
+	VolunteerStatusNo     VolunteerStatus = "no"
+)
+
+// Volunteer is the answer of a user to the call for volunteers of a nighthack.
+type Volunteer struct {
+	dbutil.Model
+	NighthackID string          `gorm:"uniqueindex:idx_volunteer_nighthack_user" json:"nighthackID"`
+	Nighthack   *Nighthack      `json:"nighthack,omitempty"`
+	UserID      string          `gorm:"uniqueindex:idx_volunteer_nighthack_user" json:"userID"`
+	User        *User           `json:"user,omitempty"`
+	Status      VolunteerStatus `json:"status"`
+}
@@ -1,6 +1,18 @@
 package nighthackbot
 
+import (
+	"fmt"
+	"os"
+)
+
 func Run() {
 	app := NewBotApp()
+	if len(os.Args) > 1 {
+		if err := app.RunCLI(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 	app.Run()
 }
@@ -0,0 +1,27 @@
+package nighthackbot
+
+import "fmt"
+
+// Role is the level of trust of a user, every role includes the permissions of the lower ones.
+type Role int
+
+const (
+	RoleAnyone Role = iota
+	RoleMember
+	RoleKeyholder
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleAnyone:    "anyone",
+	RoleMember:    "member",
+	RoleKeyholder: "keyholder",
+	RoleAdmin:     "admin",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
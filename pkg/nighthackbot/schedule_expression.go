@@ -11,7 +11,12 @@ type ScheduleExpression struct {
 	Leafs []*ScheduleExpressionLeaf
 }
 
+// ParseScheduleExpression parses comma separated human readable leafs (like "friday 18:00, first saturday 12:00")
+// or a cron expression (like "0 18 * * 5" or "@weekly").
 func ParseScheduleExpression(src string) (*ScheduleExpression, error) {
+	if looksLikeCron(src) {
+		return ParseCronExpression(src)
+	}
 	segments := strings.Split(src, ",")
 	if len(segments) == 0 {
 		return nil, fmt.Errorf("empty schedule expression")
@@ -35,11 +40,14 @@ func (s *ScheduleExpression) String() string {
 	return strings.Join(leafs, ", ")
 }
 
+// GetNextOccurence returns the earliest next occurence of the leafs or a zero time if there is none.
 func (se *ScheduleExpression) GetNextOccurence(now time.Time) time.Time {
-	// return the earliest occurence of the leafs
 	var result time.Time
 	for _, leaf := range se.Leafs {
 		t := leaf.GetNextOccurence(now)
+		if t.IsZero() {
+			continue
+		}
 		if result.IsZero() || t.Before(result) {
 			result = t
 		}
@@ -47,6 +55,24 @@ func (se *ScheduleExpression) GetNextOccurence(now time.Time) time.Time {
 	return result
 }
 
+// Occurrences returns all the occurences after from and before to.
+func (se *ScheduleExpression) Occurrences(from time.Time, to time.Time) []time.Time {
+	result := []time.Time{}
+	for t := se.GetNextOccurence(from); !t.IsZero() && t.Before(to); t = se.GetNextOccurence(t) {
+		result = append(result, t)
+	}
+	return result
+}
+
+// NextOccurrences returns up to n occurences after from.
+func (se *ScheduleExpression) NextOccurrences(from time.Time, n int) []time.Time {
+	result := []time.Time{}
+	for t := se.GetNextOccurence(from); !t.IsZero() && len(result) < n; t = se.GetNextOccurence(t) {
+		result = append(result, t)
+	}
+	return result
+}
+
 type WeekdayMask uint
 
 const (
@@ -71,6 +97,9 @@ var WeekDayNames = map[string]WeekdayMask{
 	"everyday":  AllWeekdays,
 }
 
+// weekdayOrder lists the weekday names in the order they are rendered.
+var weekdayOrder = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
 func timeWeekdayToMask(t time.Weekday) WeekdayMask {
 	switch t {
 	case time.Monday:
@@ -91,61 +120,266 @@ func timeWeekdayToMask(t time.Weekday) WeekdayMask {
 	return 0
 }
 
+// MonthNames maps month names to the bit of the month in ScheduleExpressionLeaf.Months.
+var MonthNames = map[string]time.Month{
+	"january":   time.January,
+	"february":  time.February,
+	"march":     time.March,
+	"april":     time.April,
+	"may":       time.May,
+	"june":      time.June,
+	"july":      time.July,
+	"august":    time.August,
+	"september": time.September,
+	"october":   time.October,
+	"november":  time.November,
+	"december":  time.December,
+}
+
+// Ordinals are the names of the Nth values of a leaf, "last" is -1.
+var Ordinals = map[string]int{
+	"first":  1,
+	"second": 2,
+	"third":  3,
+	"fourth": 4,
+	"fifth":  5,
+	"last":   -1,
+}
+
+const scheduleDateLayout = "2006-01-02"
+
+// scheduleSearchDays limits how far GetNextOccurence looks for a matching day.
+const scheduleSearchDays = 366 * 5
+
+// DateRange is an inclusive range of calendar days, stored as midnight UTC.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r DateRange) Contains(date time.Time) bool {
+	return !date.Before(r.From) && !date.After(r.To)
+}
+
+func (r DateRange) String() string {
+	if r.From.Equal(r.To) {
+		return r.From.Format(scheduleDateLayout)
+	}
+	return r.From.Format(scheduleDateLayout) + ".." + r.To.Format(scheduleDateLayout)
+}
+
+func ParseDateRange(src string) (DateRange, error) {
+	fromStr, toStr, isRange := strings.Cut(src, "..")
+	if !isRange {
+		toStr = fromStr
+	}
+	from, err := time.Parse(scheduleDateLayout, fromStr)
+	if err != nil {
+		return DateRange{}, fmt.Errorf("invalid date: '%s', expected YYYY-MM-DD", fromStr)
+	}
+	to, err := time.Parse(scheduleDateLayout, toStr)
+	if err != nil {
+		return DateRange{}, fmt.Errorf("invalid date: '%s', expected YYYY-MM-DD", toStr)
+	}
+	if to.Before(from) {
+		return DateRange{}, fmt.Errorf("invalid date range: '%s', the end is before the start", src)
+	}
+	return DateRange{From: from, To: to}, nil
+}
+
+// dateOf returns the calendar day of t (in the location of t) as midnight UTC.
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
 type ScheduleExpressionLeaf struct {
 	WeekdayMask WeekdayMask
-	Hour        int
-	Minute      int
+	// MonthDays has bit N set for every day of the month N the leaf occurs on, 0 means every day.
+	MonthDays uint32
+	// Months has bit N set for every month N the leaf occurs in, 0 means every month.
+	Months uint16
+	// Nth limits the leaf to the Nth matching weekday of the month, -1 is the last one and 0 means every week.
+	Nth int
+	// IntervalWeeks makes the leaf occur only every N weeks counted from From.
+	IntervalWeeks int
+	// From is the first day the leaf can occur on, zero if unbounded.
+	From   time.Time
+	Except []DateRange
+	Hour   int
+	Minute int
+	// Location is the time zone the hour and minute are in.
+	// If it is nil the location of the time passed to GetNextOccurence is used.
+	Location *time.Location
 }
 
 func (se *ScheduleExpressionLeaf) String() string {
-	dayNames := []string{}
+	parts := []string{}
+	if se.IntervalWeeks > 1 {
+		parts = append(parts, fmt.Sprintf("every %d weeks", se.IntervalWeeks))
+	}
+	for name, nth := range Ordinals {
+		if se.Nth == nth {
+			parts = append(parts, name)
+		}
+	}
+	for month := time.January; month <= time.December; month++ {
+		if se.Months&(1<<month) != 0 {
+			parts = append(parts, strings.ToLower(month.String()))
+		}
+	}
+	for day := 1; day <= 31; day++ {
+		if se.MonthDays&(1<<day) != 0 {
+			parts = append(parts, formatMonthDay(day))
+		}
+	}
+	if se.WeekdayMask&AllWeekdays == AllWeekdays {
+		if se.MonthDays == 0 && se.Months == 0 {
+			parts = append(parts, "everyday")
+		}
+	} else {
+		for _, weekday := range weekdayOrder {
+			if se.WeekdayMask&WeekDayNames[weekday] != 0 {
+				parts = append(parts, weekday)
+			}
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%02d:%02d", se.Hour, se.Minute))
+	if se.Location != nil {
+		parts = append(parts, se.Location.String())
+	}
+	if !se.From.IsZero() {
+		parts = append(parts, "from", se.From.Format(scheduleDateLayout))
+	}
+	if len(se.Except) > 0 {
+		parts = append(parts, "except")
+		for _, r := range se.Except {
+			parts = append(parts, r.String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
 
-	for weekday, mask := range WeekDayNames {
-		if se.WeekdayMask&mask != 0 {
-			dayNames = append(dayNames, weekday)
+// matchesDay checks whether the leaf occurs on the given calendar day (as returned by dateOf).
+func (se *ScheduleExpressionLeaf) matchesDay(date time.Time) bool {
+	if se.WeekdayMask&timeWeekdayToMask(date.Weekday()) == 0 {
+		return false
+	}
+	if se.MonthDays != 0 && se.MonthDays&(1<<date.Day()) == 0 {
+		return false
+	}
+	if se.Months != 0 && se.Months&(1<<date.Month()) == 0 {
+		return false
+	}
+	if !se.From.IsZero() && date.Before(se.From) {
+		return false
+	}
+	if se.Nth > 0 && (date.Day()-1)/7+1 != se.Nth {
+		return false
+	}
+	if se.Nth < 0 && date.AddDate(0, 0, 7).Month() == date.Month() {
+		return false
+	}
+	if se.IntervalWeeks > 1 {
+		weeks := int(startOfWeek(date).Sub(startOfWeek(se.From)).Hours()/24) / 7
+		if weeks%se.IntervalWeeks != 0 {
+			return false
 		}
 	}
-	if se.WeekdayMask&AllWeekdays != 0 {
-		dayNames = []string{"everyday"}
+	for _, r := range se.Except {
+		if r.Contains(date) {
+			return false
+		}
 	}
-	return fmt.Sprintf("%s %02d:%02d", strings.Join(dayNames, " "), se.Hour, se.Minute)
+	return true
 }
 
-func (se *ScheduleExpressionLeaf) GetNextOccurence(now time.Time) time.Time {
+// startOfWeek returns the monday of the week of the date.
+func startOfWeek(date time.Time) time.Time {
+	return date.AddDate(0, 0, -(int(date.Weekday())+6)%7)
+}
 
-	t, _ := time.Parse("2006/01/02", now.Format("2006/01/02"))
-	t = t.Add(time.Hour * time.Duration(se.Hour))
-	t = t.Add(time.Minute * time.Duration(se.Minute))
+// GetNextOccurence returns the first occurence after now or a zero time if the leaf never occurs again.
+func (se *ScheduleExpressionLeaf) GetNextOccurence(now time.Time) time.Time {
+	loc := se.Location
+	if loc == nil {
+		loc = now.Location()
+	}
+	year, month, day := now.In(loc).Date()
+	if start := se.From; !start.IsZero() && start.After(time.Date(year, month, day, 0, 0, 0, 0, time.UTC)) {
+		year, month, day = start.Date()
+	}
 
-	for i := 0; i < 8; i++ {
-		if se.WeekdayMask&timeWeekdayToMask(t.Weekday()) != 0 && t.After(now) {
+	// days are counted in the calendar of the location, so that the wall clock time stays the same across DST changes
+	for i := 0; i < scheduleSearchDays; i++ {
+		t := time.Date(year, month, day+i, se.Hour, se.Minute, 0, 0, loc)
+		if se.matchesDay(time.Date(year, month, day+i, 0, 0, 0, 0, time.UTC)) && t.After(now) {
 			return t
 		}
-		t = t.Add(time.Hour * 24)
 	}
-	panic("unreachable")
+	return time.Time{}
 }
 
 func ParseScheduleExpressionLeaf(src string) (ScheduleExpressionLeaf, error) {
 	se := ScheduleExpressionLeaf{}
-	parts := strings.Split(src, " ")
+	parts := strings.Fields(src)
 	if len(parts) < 2 {
 		return se, fmt.Errorf("expected day name and hour, only one part found: '%s'", src)
 	}
-	for _, part := range parts[:len(parts)-1] {
-		part = strings.ToLower(part)
+	i := 0
+
+	// every N weeks
+	if strings.ToLower(parts[i]) == "every" {
+		if i+2 >= len(parts) {
+			return se, fmt.Errorf("expected 'every N weeks': '%s'", src)
+		}
+		interval, err := strconv.Atoi(parts[i+1])
+		if err != nil || interval < 1 {
+			return se, fmt.Errorf("invalid week interval: '%s'", parts[i+1])
+		}
+		if unit := strings.ToLower(parts[i+2]); unit != "weeks" && unit != "week" {
+			return se, fmt.Errorf("expected 'weeks' after the interval, found: '%s'", parts[i+2])
+		}
+		se.IntervalWeeks = interval
+		i += 3
+	}
+
+	// first, second, ..., last
+	if i < len(parts) {
+		if nth, ok := Ordinals[strings.ToLower(parts[i])]; ok {
+			se.Nth = nth
+			i++
+		}
+	}
+
+	// day names, month names and days of the month up to the hour
+	for ; i < len(parts) && !strings.Contains(parts[i], ":"); i++ {
+		part := strings.ToLower(parts[i])
 		if weekday, ok := WeekDayNames[part]; ok {
 			se.WeekdayMask |= weekday
+		} else if month, ok := MonthNames[part]; ok {
+			se.Months |= 1 << month
+		} else if day, ok := parseMonthDay(part); ok {
+			se.MonthDays |= 1 << day
 		} else {
 			allowedNames := []string{}
 			for name := range WeekDayNames {
 				allowedNames = append(allowedNames, name)
 			}
-			return se, fmt.Errorf("invalid day name: '%s', allowed names are: %v", src, strings.Join(allowedNames, ", "))
+			return se, fmt.Errorf("invalid day name: '%s', allowed names are: %v, month names and days of the month like 1st", parts[i], strings.Join(allowedNames, ", "))
 		}
 	}
+	if se.WeekdayMask == 0 && (se.MonthDays != 0 || se.Months != 0) {
+		se.WeekdayMask = AllWeekdays
+	}
+	if se.WeekdayMask == 0 {
+		return se, fmt.Errorf("expected at least one day name: '%s'", src)
+	}
+	if i >= len(parts) {
+		return se, fmt.Errorf("expected hour and minute, found: '%s'", src)
+	}
 
-	timeParts := strings.Split(parts[len(parts)-1], ":")
+	timeParts := strings.Split(parts[i], ":")
 	if len(timeParts) != 2 {
 		return se, fmt.Errorf("expected hour and minute, found: '%s'", src)
 	}
@@ -165,5 +399,78 @@ func ParseScheduleExpressionLeaf(src string) (ScheduleExpressionLeaf, error) {
 	}
 	se.Hour = hour
 	se.Minute = minute
+	i++
+
+	// time zone, from and except in any order
+	for i < len(parts) {
+		switch strings.ToLower(parts[i]) {
+		case "from":
+			if i+1 >= len(parts) {
+				return se, fmt.Errorf("expected a date after 'from': '%s'", src)
+			}
+			from, err := time.Parse(scheduleDateLayout, parts[i+1])
+			if err != nil {
+				return se, fmt.Errorf("invalid date: '%s', expected YYYY-MM-DD", parts[i+1])
+			}
+			se.From = from
+			i += 2
+		case "except":
+			i++
+			if i >= len(parts) {
+				return se, fmt.Errorf("expected dates after 'except': '%s'", src)
+			}
+			for ; i < len(parts) && len(parts[i]) > 0 && parts[i][0] >= '0' && parts[i][0] <= '9'; i++ {
+				r, err := ParseDateRange(parts[i])
+				if err != nil {
+					return se, err
+				}
+				se.Except = append(se.Except, r)
+			}
+		default:
+			if se.Location != nil {
+				return se, fmt.Errorf("unexpected '%s' in '%s'", parts[i], src)
+			}
+			loc, err := time.LoadLocation(parts[i])
+			if err != nil {
+				return se, fmt.Errorf("invalid time zone: '%s'", parts[i])
+			}
+			se.Location = loc
+			i++
+		}
+	}
+
+	if se.IntervalWeeks > 1 && se.From.IsZero() {
+		return se, fmt.Errorf("'every %d weeks' requires a 'from' date: '%s'", se.IntervalWeeks, src)
+	}
+	if se.IntervalWeeks == 1 {
+		se.IntervalWeeks = 0
+	}
 	return se, nil
 }
+
+// formatMonthDay formats a day of the month as 1st, 2nd, 3rd, 4th...
+func formatMonthDay(day int) string {
+	suffix := "th"
+	if day < 11 || day > 13 {
+		switch day % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(day) + suffix
+}
+
+func parseMonthDay(src string) (int, bool) {
+	if len(src) < 3 {
+		return 0, false
+	}
+	day, err := strconv.Atoi(src[:len(src)-2])
+	if err != nil || day < 1 || day > 31 || formatMonthDay(day) != src {
+		return 0, false
+	}
+	return day, true
+}
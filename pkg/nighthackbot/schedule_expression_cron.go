@@ -0,0 +1,333 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CronShorthands are the predefined cron schedules accepted by ParseCronExpression.
+var CronShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// maxCronLeafs limits the leafs a cron expression expands into, one for every time of the day it occurs at.
+// Nighthacks happen a few times a week, more times only make the schedule slow and unreadable in its human form.
+const maxCronLeafs = 24
+
+var cronMinuteFieldRegexp = regexp.MustCompile(`^[0-9*,/-]+$`)
+
+// cronWeekdays maps the cron day of week numbers to weekday masks.
+var cronWeekdays = []WeekdayMask{
+	WeekdaySunday, WeekdayMonday, WeekdayTuesday, WeekdayWednesday, WeekdayThursday, WeekdayFriday, WeekdaySaturday, WeekdaySunday,
+}
+
+// looksLikeCron reports whether src should be parsed as a cron expression rather than the human form.
+func looksLikeCron(src string) bool {
+	fields := strings.Fields(src)
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		return true
+	}
+	return len(fields) == 5 && cronMinuteFieldRegexp.MatchString(fields[0])
+}
+
+// ParseCronExpression parses a standard 5-field cron expression or one of CronShorthands.
+// When both the day of month and the day of week are restricted the schedule occurs on either, like in cron.
+func ParseCronExpression(src string) (*ScheduleExpression, error) {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(src, "@") {
+		expanded, ok := CronShorthands[strings.ToLower(src)]
+		if !ok {
+			names := []string{}
+			for name := range CronShorthands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown cron shorthand '%s', allowed are: %v", src, strings.Join(names, ", "))
+		}
+		src = expanded
+	}
+	fields := strings.Fields(src)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 cron fields (minute hour day-of-month month day-of-week), found %d: '%s'", len(fields), src)
+	}
+	values := make([][]int, len(fields))
+	for i, field := range fields {
+		var err error
+		values[i], err = cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("cron field %d (%s) '%s': %w", i+1, cronFields[i].name, field, err)
+		}
+	}
+
+	var monthDays uint32
+	if len(values[2]) != 31 {
+		for _, day := range values[2] {
+			monthDays |= 1 << day
+		}
+	}
+	var months uint16
+	if len(values[3]) != 12 {
+		for _, month := range values[3] {
+			months |= 1 << month
+		}
+	}
+	var weekdays WeekdayMask
+	for _, weekday := range values[4] {
+		weekdays |= cronWeekdays[weekday]
+	}
+
+	// the leafs differing only in the time
+	templates := []ScheduleExpressionLeaf{}
+	if !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*") {
+		// like in cron, when both days are restricted either of them matches
+		templates = append(templates,
+			ScheduleExpressionLeaf{WeekdayMask: AllWeekdays, MonthDays: monthDays, Months: months},
+			ScheduleExpressionLeaf{WeekdayMask: weekdays, Months: months},
+		)
+	} else {
+		templates = append(templates, ScheduleExpressionLeaf{WeekdayMask: weekdays, MonthDays: monthDays, Months: months})
+	}
+
+	if count := len(templates) * len(values[1]) * len(values[0]); count > maxCronLeafs {
+		return nil, fmt.Errorf("'%s' occurs at %d times of the day, at most %d are supported", src, count, maxCronLeafs)
+	}
+	se := &ScheduleExpression{}
+	for _, template := range templates {
+		for _, hour := range values[1] {
+			for _, minute := range values[0] {
+				leaf := template
+				leaf.Hour = hour
+				leaf.Minute = minute
+				se.Leafs = append(se.Leafs, &leaf)
+			}
+		}
+	}
+	return se, nil
+}
+
+// parse returns the sorted values matched by a single cron field.
+func (f cronField) parse(src string) ([]int, error) {
+	matched := map[int]bool{}
+	for _, item := range strings.Split(src, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%s'", stepStr)
+			}
+		}
+		from, to := f.min, f.max
+		if rangeStr != "*" {
+			fromStr, toStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			if from, err = f.value(fromStr); err != nil {
+				return nil, err
+			}
+			to = from
+			if isRange {
+				if to, err = f.value(toStr); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				to = f.max
+			}
+			if to < from {
+				return nil, fmt.Errorf("invalid range '%s', the end is before the start", rangeStr)
+			}
+		}
+		for v := from; v <= to; v += step {
+			matched[v] = true
+		}
+	}
+	result := []int{}
+	for v := range matched {
+		result = append(result, v)
+	}
+	sort.Ints(result)
+	return result, nil
+}
+
+func (f cronField) value(src string) (int, error) {
+	if v, ok := f.names[strings.ToLower(src)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(src)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", src)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// CronString renders the expression as a single 5-field cron expression.
+// It fails if the expression uses features cron does not have, like time zones or every-other-week.
+func (s *ScheduleExpression) CronString() (string, error) {
+	if len(s.Leafs) == 0 {
+		return "", fmt.Errorf("empty schedule expression")
+	}
+	type dayKey struct {
+		weekdays  WeekdayMask
+		monthDays uint32
+		months    uint16
+	}
+	groups := map[dayKey]map[[2]int]bool{}
+	keys := []dayKey{}
+	for _, leaf := range s.Leafs {
+		if leaf.Location != nil || leaf.Nth != 0 || leaf.IntervalWeeks > 1 || !leaf.From.IsZero() || len(leaf.Except) > 0 {
+			return "", fmt.Errorf("'%s' cannot be represented in cron", leaf.String())
+		}
+		key := dayKey{leaf.WeekdayMask & AllWeekdays, leaf.MonthDays, leaf.Months}
+		if groups[key] == nil {
+			groups[key] = map[[2]int]bool{}
+			keys = append(keys, key)
+		}
+		groups[key][[2]int{leaf.Hour, leaf.Minute}] = true
+	}
+
+	// all the groups need the same times, which have to be a product of hours and minutes
+	var times map[[2]int]bool
+	for _, key := range keys {
+		if times == nil {
+			times = groups[key]
+		} else if !sameTimes(times, groups[key]) {
+			return "", fmt.Errorf("the times of '%s' cannot be represented in a single cron expression", s.String())
+		}
+	}
+	hours, minutes := map[int]bool{}, map[int]bool{}
+	for t := range times {
+		hours[t[0]] = true
+		minutes[t[1]] = true
+	}
+	if len(hours)*len(minutes) != len(times) {
+		return "", fmt.Errorf("the times of '%s' cannot be represented in a single cron expression", s.String())
+	}
+
+	var monthDays uint32
+	var weekdays WeekdayMask
+	var months uint16
+	switch len(keys) {
+	case 1:
+		weekdays, monthDays, months = keys[0].weekdays, keys[0].monthDays, keys[0].months
+		if monthDays != 0 && weekdays != AllWeekdays {
+			return "", fmt.Errorf("'%s' requires both the day of month and the day of week, which cron does not support", s.String())
+		}
+	case 2:
+		// cron matches either the day of month or the day of week
+		a, b := keys[0], keys[1]
+		if a.monthDays == 0 {
+			a, b = b, a
+		}
+		if a.months != b.months || a.monthDays == 0 || a.weekdays != AllWeekdays || b.monthDays != 0 || b.weekdays == AllWeekdays {
+			return "", fmt.Errorf("the days of '%s' cannot be represented in a single cron expression", s.String())
+		}
+		weekdays, monthDays, months = b.weekdays, a.monthDays, a.months
+	default:
+		return "", fmt.Errorf("the days of '%s' cannot be represented in a single cron expression", s.String())
+	}
+
+	fields := []string{
+		formatCronField(setToList(minutes), 0, 59),
+		formatCronField(setToList(hours), 0, 23),
+		formatCronField(maskToList(uint64(monthDays), 1, 31), 1, 31),
+		formatCronField(maskToList(uint64(months), 1, 12), 1, 12),
+		"*",
+	}
+	if weekdays != AllWeekdays {
+		dow := []int{}
+		for i, mask := range cronWeekdays[:7] {
+			if weekdays&mask != 0 {
+				dow = append(dow, i)
+			}
+		}
+		fields[4] = formatCronField(dow, 0, 6)
+	}
+	return strings.Join(fields, " "), nil
+}
+
+func sameTimes(a, b map[[2]int]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for t := range a {
+		if !b[t] {
+			return false
+		}
+	}
+	return true
+}
+
+func setToList(set map[int]bool) []int {
+	result := []int{}
+	for v := range set {
+		result = append(result, v)
+	}
+	sort.Ints(result)
+	return result
+}
+
+func maskToList(mask uint64, min, max int) []int {
+	result := []int{}
+	for v := min; v <= max; v++ {
+		if mask&(1<<v) != 0 {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// formatCronField renders sorted values as a cron field, using * and ranges where possible.
+func formatCronField(values []int, min, max int) string {
+	if len(values) == 0 || len(values) == max-min+1 {
+		return "*"
+	}
+	items := []string{}
+	for i := 0; i < len(values); {
+		j := i
+		for j+1 < len(values) && values[j+1] == values[j]+1 {
+			j++
+		}
+		switch {
+		case j-i >= 2:
+			items = append(items, fmt.Sprintf("%d-%d", values[i], values[j]))
+		case j-i == 1:
+			items = append(items, strconv.Itoa(values[i]), strconv.Itoa(values[j]))
+		default:
+			items = append(items, strconv.Itoa(values[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,174 @@
+package nighthackbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronExpression(t *testing.T) {
+	tests := []struct {
+		cron     string
+		human    string
+		rendered string
+		now      string
+		expected string
+	}{
+		{
+			cron:     "0 18 * * 5",
+			human:    "friday 18:00",
+			rendered: "0 18 * * 5",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-08-12T18:00:00Z",
+		},
+		{
+			cron:     "30 18 * * mon,wed",
+			human:    "monday wednesday 18:30",
+			rendered: "30 18 * * 1,3",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-08-15T18:30:00Z",
+		},
+		{
+			cron:     "0 18 * * 7",
+			human:    "sunday 18:00",
+			rendered: "0 18 * * 0",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-08-14T18:00:00Z",
+		},
+		{
+			cron:     "@weekly",
+			human:    "sunday 00:00",
+			rendered: "0 0 * * 0",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-08-14T00:00:00Z",
+		},
+		{
+			cron:     "@daily",
+			human:    "everyday 00:00",
+			rendered: "0 0 * * *",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-08-13T00:00:00Z",
+		},
+		{
+			cron:     "@monthly",
+			human:    "1st 00:00",
+			rendered: "0 0 1 * *",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-09-01T00:00:00Z",
+		},
+		{
+			cron:     "0 12 24,31 dec *",
+			human:    "december 24th 31st 12:00",
+			rendered: "0 12 24,31 12 *",
+			now:      "2022-08-12T16:00:00Z",
+			expected: "2022-12-24T12:00:00Z",
+		},
+		{
+			cron:     "0 18,20 * * 1-5",
+			human:    "monday tuesday wednesday thursday friday 18:00, monday tuesday wednesday thursday friday 20:00",
+			rendered: "0 18,20 * * 1-5",
+			now:      "2022-08-12T19:00:00Z",
+			expected: "2022-08-12T20:00:00Z",
+		},
+		{
+			cron:     "0 18 13 * 5",
+			human:    "13th 18:00, friday 18:00",
+			rendered: "0 18 13 * 5",
+			now:      "2022-08-12T19:00:00Z",
+			expected: "2022-08-13T18:00:00Z",
+		},
+		{
+			cron:     "0 9 */10 * *",
+			human:    "1st 11th 21st 31st 09:00",
+			rendered: "0 9 1,11,21,31 * *",
+			now:      "2022-08-12T19:00:00Z",
+			expected: "2022-08-21T09:00:00Z",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.cron, func(t *testing.T) {
+			parsed, err := ParseScheduleExpression(test.cron)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.String() != test.human {
+				t.Fatalf("expected human form %q, got %q", test.human, parsed.String())
+			}
+			rendered, err := parsed.CronString()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered != test.rendered {
+				t.Fatalf("expected cron form %q, got %q", test.rendered, rendered)
+			}
+
+			// the human form parses into the same schedule
+			reparsed, err := ParseScheduleExpression(test.human)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered, err := reparsed.CronString(); err != nil || rendered != test.rendered {
+				t.Fatalf("expected the human form to render as %q, got %q (%v)", test.rendered, rendered, err)
+			}
+
+			now, _ := time.Parse(time.RFC3339, test.now)
+			next := parsed.GetNextOccurence(now)
+			if next.Format(time.RFC3339) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, next.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestCronExpressionErrors(t *testing.T) {
+	tests := []struct {
+		cron  string
+		field string
+	}{
+		{"60 18 * * 5", "cron field 1 (minute) '60'"},
+		{"0 24 * * 5", "cron field 2 (hour) '24'"},
+		{"0 18 0 * *", "cron field 3 (day of month) '0'"},
+		{"0 18 * 13 *", "cron field 4 (month) '13'"},
+		{"0 18 * * fry", "cron field 5 (day of week) 'fry'"},
+		{"0 18 * * 5-1", "cron field 5 (day of week) '5-1'"},
+		{"*/0 18 * * 5", "cron field 1 (minute) '*/0'"},
+		{"@fortnightly", "unknown cron shorthand"},
+		{"* * * * *", "occurs at 1440 times of the day, at most 24"},
+		{"0,30 * * * 5", "occurs at 48 times of the day"},
+		{"*/5 18,19,20 13 * 5", "occurs at 72 times of the day"},
+	}
+	for _, test := range tests {
+		t.Run(test.cron, func(t *testing.T) {
+			_, err := ParseScheduleExpression(test.cron)
+			if err == nil {
+				t.Fatalf("expected an error for %q", test.cron)
+			}
+			if !strings.Contains(err.Error(), test.field) {
+				t.Fatalf("expected the error to contain %q, got %q", test.field, err.Error())
+			}
+		})
+	}
+}
+
+func TestCronStringUnsupported(t *testing.T) {
+	tests := []string{
+		"first friday 18:00",
+		"friday 18:00 Europe/Warsaw",
+		"every 2 weeks friday 18:00 from 2026-01-02",
+		"friday 18:00 except 2026-12-25",
+		"13th friday 18:00",
+		"friday 18:00, saturday 12:00",
+		"friday 18:00, friday 19:30",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			parsed, err := ParseScheduleExpression(test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rendered, err := parsed.CronString(); err == nil {
+				t.Fatalf("expected an error, got %q", rendered)
+			}
+		})
+	}
+}
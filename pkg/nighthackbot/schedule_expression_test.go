@@ -52,3 +52,257 @@ func TestScheduleExpressionEveryday(t *testing.T) {
 	}
 
 }
+
+func TestScheduleExpressionTimezones(t *testing.T) {
+	warsaw, err := time.LoadLocation("Europe/Warsaw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		expr     string
+		now      string
+		location *time.Location
+		expected string
+	}{
+		{
+			name:     "explicit time zone in winter",
+			expr:     "sunday 18:00 Europe/Warsaw",
+			now:      "2026-03-22T12:00:00Z",
+			expected: "2026-03-22T17:00:00Z",
+		},
+		{
+			name:     "spring forward keeps the wall clock time",
+			expr:     "sunday 18:00 Europe/Warsaw",
+			now:      "2026-03-28T12:00:00Z",
+			expected: "2026-03-29T16:00:00Z",
+		},
+		{
+			name:     "spring forward from the week before",
+			expr:     "sunday 18:00 Europe/Warsaw",
+			now:      "2026-03-22T18:00:00Z",
+			expected: "2026-03-29T16:00:00Z",
+		},
+		{
+			name:     "time skipped by spring forward is moved forward",
+			expr:     "sunday 02:30 Europe/Warsaw",
+			now:      "2026-03-28T12:00:00Z",
+			expected: "2026-03-29T01:30:00Z",
+		},
+		{
+			name:     "fall back keeps the wall clock time",
+			expr:     "sunday 18:00 Europe/Warsaw",
+			now:      "2026-10-24T12:00:00Z",
+			expected: "2026-10-25T17:00:00Z",
+		},
+		{
+			name:     "fall back from the week before",
+			expr:     "sunday 18:00 Europe/Warsaw",
+			now:      "2026-10-18T17:00:00Z",
+			expected: "2026-10-25T17:00:00Z",
+		},
+		{
+			name:     "time repeated by fall back happens once",
+			expr:     "sunday 02:30 Europe/Warsaw",
+			now:      "2026-10-24T12:00:00Z",
+			expected: "2026-10-25T01:30:00Z",
+		},
+		{
+			name:     "time repeated by fall back is not repeated",
+			expr:     "sunday 02:30 Europe/Warsaw",
+			now:      "2026-10-25T01:30:00Z",
+			expected: "2026-11-01T01:30:00Z",
+		},
+		{
+			name:     "everyday across fall back",
+			expr:     "everyday 00:30 Europe/Warsaw",
+			now:      "2026-10-24T23:00:00Z",
+			expected: "2026-10-25T23:30:00Z",
+		},
+		{
+			name:     "location of now is used without an explicit time zone",
+			expr:     "sunday 18:00",
+			now:      "2026-03-28T12:00:00Z",
+			location: warsaw,
+			expected: "2026-03-29T16:00:00Z",
+		},
+		{
+			name:     "explicit time zone wins over the location of now",
+			expr:     "sunday 18:00 UTC",
+			now:      "2026-03-28T12:00:00Z",
+			location: warsaw,
+			expected: "2026-03-29T18:00:00Z",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, test.now)
+			if test.location != nil {
+				now = now.In(test.location)
+			}
+			parsed, err := ParseScheduleExpression(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := parsed.GetNextOccurence(now)
+			if next.UTC().Format(time.RFC3339) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, next.UTC().Format(time.RFC3339))
+			}
+			if parsed.String() != test.expr {
+				t.Fatalf("expected %q to round-trip, got %q", test.expr, parsed.String())
+			}
+		})
+	}
+}
+
+func TestScheduleExpressionGrammar(t *testing.T) {
+	tests := []struct {
+		expr     string
+		now      string
+		expected string
+	}{
+		{
+			expr:     "first friday 19:00",
+			now:      "2026-01-03T12:00:00Z",
+			expected: "2026-02-06T19:00:00Z",
+		},
+		{
+			expr:     "first friday 19:00",
+			now:      "2026-01-01T12:00:00Z",
+			expected: "2026-01-02T19:00:00Z",
+		},
+		{
+			expr:     "third tuesday thursday 18:00",
+			now:      "2026-01-01T00:00:00Z",
+			expected: "2026-01-15T18:00:00Z",
+		},
+		{
+			expr:     "last saturday 14:00",
+			now:      "2026-01-01T12:00:00Z",
+			expected: "2026-01-31T14:00:00Z",
+		},
+		{
+			expr:     "last saturday 14:00",
+			now:      "2026-01-31T15:00:00Z",
+			expected: "2026-02-28T14:00:00Z",
+		},
+		{
+			expr:     "fifth friday 18:00",
+			now:      "2026-02-01T00:00:00Z",
+			expected: "2026-05-29T18:00:00Z",
+		},
+		{
+			expr:     "every 2 weeks friday 18:00 from 2026-01-02",
+			now:      "2026-01-03T00:00:00Z",
+			expected: "2026-01-16T18:00:00Z",
+		},
+		{
+			expr:     "every 2 weeks friday 18:00 from 2026-01-02",
+			now:      "2025-06-01T00:00:00Z",
+			expected: "2026-01-02T18:00:00Z",
+		},
+		{
+			expr:     "every 3 weeks monday friday 18:00 from 2026-01-07",
+			now:      "2026-01-10T00:00:00Z",
+			expected: "2026-01-26T18:00:00Z",
+		},
+		{
+			expr:     "friday 18:00 from 2026-03-01",
+			now:      "2026-01-01T00:00:00Z",
+			expected: "2026-03-06T18:00:00Z",
+		},
+		{
+			expr:     "friday 18:00 except 2026-12-25..2027-01-02",
+			now:      "2026-12-20T00:00:00Z",
+			expected: "2027-01-08T18:00:00Z",
+		},
+		{
+			expr:     "friday 18:00 except 2026-12-25 2027-01-01",
+			now:      "2026-12-20T00:00:00Z",
+			expected: "2027-01-08T18:00:00Z",
+		},
+		{
+			expr:     "every 2 weeks first friday 19:00 Europe/Warsaw from 2026-01-02 except 2026-03-06",
+			now:      "2026-01-03T00:00:00Z",
+			expected: "2026-06-05T17:00:00Z",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, test.now)
+			parsed, err := ParseScheduleExpression(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.String() != test.expr {
+				t.Fatalf("expected %q to round-trip, got %q", test.expr, parsed.String())
+			}
+			next := parsed.GetNextOccurence(now)
+			if next.UTC().Format(time.RFC3339) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, next.UTC().Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestScheduleExpressionNeverOccurs(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2026-01-01T00:00:00Z")
+	parsed, err := ParseScheduleExpression("friday 18:00 except 2026-01-01..2036-01-01, everyday 10:00 except 2026-01-01..2036-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := parsed.GetNextOccurence(now); !next.IsZero() {
+		t.Fatalf("expected no occurence, got %s", next.Format(time.RFC3339))
+	}
+}
+
+func TestScheduleExpressionParseErrors(t *testing.T) {
+	tests := []string{
+		"friday",
+		"18:00",
+		"fryday 18:00",
+		"friday 25:00",
+		"friday 18:60",
+		"friday 1800",
+		"first 18:00",
+		"every 2 weeks friday 18:00",
+		"every two weeks friday 18:00 from 2026-01-02",
+		"every 2 days friday 18:00 from 2026-01-02",
+		"friday 18:00 from tomorrow",
+		"friday 18:00 except",
+		"friday 18:00 except 2026-12-25..2026-12-20",
+		"friday 18:00 Mars/Olympus_Mons",
+		"friday 18:00 UTC Europe/Warsaw",
+	}
+	for _, test := range tests {
+		t.Run(test, func(t *testing.T) {
+			if _, err := ParseScheduleExpression(test); err == nil {
+				t.Fatalf("expected an error for %q", test)
+			}
+		})
+	}
+}
+
+func TestScheduleExpressionOccurrences(t *testing.T) {
+	from, _ := time.Parse(time.RFC3339, "2022-08-12T18:00:00Z")
+	to, _ := time.Parse(time.RFC3339, "2022-08-20T00:00:00Z")
+	parsed, err := ParseScheduleExpression("friday 18:00, tuesday 19:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2022-08-16T19:00:00Z", "2022-08-19T18:00:00Z"}
+	occurrences := parsed.Occurrences(from, to)
+	if len(occurrences) != len(expected) {
+		t.Fatalf("expected %d occurences, got %v", len(expected), occurrences)
+	}
+	for i, occurrence := range occurrences {
+		if occurrence.Format(time.RFC3339) != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], occurrence.Format(time.RFC3339))
+		}
+	}
+
+	next := parsed.NextOccurrences(from, 3)
+	if len(next) != 3 || next[2].Format(time.RFC3339) != "2022-08-23T19:00:00Z" {
+		t.Fatalf("unexpected next occurences: %v", next)
+	}
+}
@@ -1,81 +1,346 @@
 package nighthackbot
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"strings"
+	"html"
 	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
 )
 
+// askTimeout is how long a question waits for an answer
+const askTimeout = time.Minute * 10
+
+// confirmedAnswer is recorded when a Confirm question is answered with yes
+const confirmedAnswer = "/yes"
+
 type AskService struct {
-	BotApp            *BotApp
-	AskCallbacks      map[int64]func(string, error)
-	AskCallbacksMutex sync.Mutex
+	BotApp *BotApp
+
+	registry *askRegistry
+	mutex    sync.Mutex
+	// conversations are the commands currently running for each user in each chat
+	conversations map[askKey]*Conversation
+	// resumed are the pending questions of the commands resumed after a restart, until their conversation begins
+	resumed map[askKey]*PendingQuestion
 }
 
 func NewAskService(botApp *BotApp) *AskService {
 	return &AskService{
-		BotApp:       botApp,
-		AskCallbacks: map[int64]func(string, error){},
+		BotApp:        botApp,
+		registry:      newAskRegistry(),
+		conversations: map[askKey]*Conversation{},
+		resumed:       map[askKey]*PendingQuestion{},
 	}
 }
 
-func (a *AskService) ProcessIncomingMessage(update tgbotapi.Update) bool {
-	a.AskCallbacksMutex.Lock()
-	defer a.AskCallbacksMutex.Unlock()
+// Conversation is a command exchanging questions and answers with a user.
+// The answers are recorded, so that after a restart the command can be executed again
+// with the recorded answers replayed until it reaches the question which was pending.
+// Only the commands which are ReplaySafeCommands are resumed.
+type Conversation struct {
+	ChatID      int64
+	ChatType    string
+	UserID      int64
+	UserName    string
+	CommandText string
+	// MessageID is the message with the command, the questions reply to it so only its author is asked
+	MessageID int
+	// CallbackMessageID is the message with the button which executed the command
+	CallbackMessageID int
+	ReplaySafe        bool
+	// ctx is canceled when the bot shuts down, the questions stop waiting and stay saved for the next start
+	ctx             context.Context
+	answers         []string
+	replay          []string
+	pendingQuestion *PendingQuestion
+}
 
-	if update.CallbackQuery != nil {
-		if update.CallbackQuery.Data == "/cancel" {
+func (c *Conversation) key() askKey {
+	return askKey{c.ChatID, c.UserID}
+}
 
-			if callback, ok := a.AskCallbacks[update.CallbackQuery.Message.Chat.ID]; ok {
-				a.BotApp.Bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, "Canceled"))
-				callback("", errors.New("canceled"))
-				delete(a.AskCallbacks, update.CallbackQuery.Message.Chat.ID)
-			}
-			return true
+func (c *Conversation) context() context.Context {
+	if c == nil || c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// BeginConversation registers the command the user is running in the chat. The replayed answers are returned
+// by the questions of the command instead of asking the user.
+func (a *AskService) BeginConversation(ctx context.Context, args *CommandArguments, commandText string, messageID int, replay []string) *Conversation {
+	conversation := &Conversation{
+		ctx:         ctx,
+		ChatID:      args.ChatID,
+		ChatType:    args.ChatType,
+		UserID:      args.FromUserID,
+		UserName:    args.FromUserName,
+		CommandText: commandText,
+		MessageID:   messageID,
+		ReplaySafe:  commandIsReplaySafe(args),
+		replay:      replay,
+	}
+	if args.update != nil && args.update.CallbackQuery != nil && args.update.CallbackQuery.Message != nil {
+		conversation.CallbackMessageID = args.update.CallbackQuery.Message.MessageID
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if replay != nil {
+		// the resumed command keeps its pending question until it finishes
+		conversation.pendingQuestion = a.resumed[conversation.key()]
+		delete(a.resumed, conversation.key())
+	}
+	a.conversations[conversation.key()] = conversation
+	return conversation
+}
+
+// discardResumed deletes the pending question of a resumed command which failed before its conversation began.
+func (a *AskService) discardResumed(key askKey) {
+	a.mutex.Lock()
+	pending := a.resumed[key]
+	delete(a.resumed, key)
+	a.mutex.Unlock()
+	if pending == nil {
+		return
+	}
+	if err := a.BotApp.DB.Unscoped().Delete(pending).Error; err != nil {
+		log.Error().Err(err).Msgf("Failed to delete pending question")
+	}
+}
+
+// EndConversation forgets the conversation once its command has finished.
+// The pending question of a conversation interrupted by a shutdown is kept, so it is resumed on the next start.
+func (a *AskService) EndConversation(conversation *Conversation) {
+	a.mutex.Lock()
+	if a.conversations[conversation.key()] == conversation {
+		delete(a.conversations, conversation.key())
+	}
+	pending := conversation.pendingQuestion
+	conversation.pendingQuestion = nil
+	a.mutex.Unlock()
+	if pending != nil && conversation.context().Err() == nil {
+		if err := a.BotApp.DB.Unscoped().Delete(pending).Error; err != nil {
+			log.Error().Err(err).Msgf("Failed to delete pending question")
 		}
-		if update.CallbackQuery.Data == "/yes" {
-			if callback, ok := a.AskCallbacks[update.CallbackQuery.Message.Chat.ID]; ok {
-				a.BotApp.Bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, "Confirmed"))
-				callback("", nil)
-				delete(a.AskCallbacks, update.CallbackQuery.Message.Chat.ID)
-			}
-			return true
+	}
+}
+
+func (a *AskService) conversation(key askKey) *Conversation {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.conversations[key]
+}
+
+// replayAnswer returns the next recorded answer if the conversation is being resumed.
+func (a *AskService) replayAnswer(conversation *Conversation) (string, bool) {
+	if conversation == nil {
+		return "", false
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(conversation.replay) == 0 {
+		return "", false
+	}
+	answer := conversation.replay[0]
+	conversation.replay = conversation.replay[1:]
+	conversation.answers = append(conversation.answers, answer)
+	return answer, true
+}
+
+func (a *AskService) recordAnswer(conversation *Conversation, answer string) {
+	if conversation == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	conversation.answers = append(conversation.answers, answer)
+}
+
+// savePendingQuestion stores the question with the conversation state so it survives a restart.
+func (a *AskService) savePendingQuestion(conversation *Conversation, kind PendingQuestionKind, question string, messageID int) {
+	if conversation == nil {
+		return
+	}
+	a.mutex.Lock()
+	answers, err := json.Marshal(append([]string{}, conversation.answers...))
+	pending := conversation.pendingQuestion
+	if pending == nil {
+		pending = &PendingQuestion{}
+		conversation.pendingQuestion = pending
+	}
+	pending.ChatID = conversation.ChatID
+	pending.ChatType = conversation.ChatType
+	pending.UserTelegramID = conversation.UserID
+	pending.UserName = conversation.UserName
+	pending.CommandText = conversation.CommandText
+	pending.CommandMessageID = conversation.MessageID
+	pending.CallbackMessageID = conversation.CallbackMessageID
+	pending.ReplaySafe = conversation.ReplaySafe
+	pending.Kind = kind
+	pending.Question = question
+	pending.MessageID = messageID
+	pending.Answers = string(answers)
+	a.mutex.Unlock()
+	if err == nil {
+		err = a.BotApp.DB.Save(pending).Error
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to save pending question")
+	}
+}
+
+// ResumePendingQuestions executes the commands which were waiting for an answer when the bot stopped,
+// replaying the answers given so far, which asks the pending question again. The pending question is deleted
+// once the resumed command finishes. Commands which are not replay safe are not executed again,
+// the user is asked to start them over instead.
+func (a *AskService) ResumePendingQuestions(ctx context.Context) error {
+	pendingQuestions := []PendingQuestion{}
+	if err := a.BotApp.DB.Order("updated_at").Find(&pendingQuestions).Error; err != nil {
+		return err
+	}
+	for i := range pendingQuestions {
+		pending := &pendingQuestions[i]
+		if pending.MessageID != 0 {
+			a.BotApp.Messenger.Delete(pending.ChatID, pending.MessageID)
+		}
+		answers := []string{}
+		if err := json.Unmarshal([]byte(pending.Answers), &answers); err != nil {
+			log.Error().Err(err).Msgf("Failed to decode answers of pending question")
+			pending.ReplaySafe = false
 		}
-		if strings.HasPrefix(update.CallbackQuery.Data, "/sugg ") {
-			val := strings.TrimPrefix(update.CallbackQuery.Data, "/sugg ")
-			if callback, ok := a.AskCallbacks[update.CallbackQuery.Message.Chat.ID]; ok {
-				a.BotApp.Bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
-				callback(val, nil)
-				delete(a.AskCallbacks, update.CallbackQuery.Message.Chat.ID)
+		expired := time.Since(pending.UpdatedAt) > askTimeout
+		if expired || !pending.ReplaySafe {
+			if err := a.BotApp.DB.Unscoped().Delete(pending).Error; err != nil {
+				return err
 			}
+			if expired {
+				log.Info().Int64("chat_id", pending.ChatID).Str("command", pending.CommandText).Msgf("Dropped expired pending question")
+				continue
+			}
+			log.Info().Int64("chat_id", pending.ChatID).Str("command", pending.CommandText).Msgf("Dropped pending question of a command which cannot be resumed")
+			msg := tgbotapi.NewMessage(pending.ChatID, fmt.Sprintf(
+				"⚠️ The bot was restarted while waiting for your answer, please run <code>%v</code> again.",
+				html.EscapeString(pending.CommandText),
+			))
+			msg.ParseMode = "HTML"
+			msg.ReplyToMessageID = pending.CommandMessageID
+			msg.AllowSendingWithoutReply = true
+			a.BotApp.Messenger.Send(msg)
+			continue
 		}
+		log.Info().
+			Int64("chat_id", pending.ChatID).
+			Str("command", pending.CommandText).
+			Int("answers", len(answers)).
+			Msgf("Resuming pending question")
+		a.mutex.Lock()
+		a.resumed[askKey{pending.ChatID, pending.UserTelegramID}] = pending
+		a.mutex.Unlock()
+		a.BotApp.dispatchUpdate(ctx, resumedUpdate(pending), answers)
 	}
+	return nil
+}
 
-	if update.Message != nil {
-		if strings.HasPrefix(update.Message.Text, "/") {
-			if callback, ok := a.AskCallbacks[update.Message.Chat.ID]; ok {
-				callback("", errors.New("canceled"))
-				delete(a.AskCallbacks, update.Message.Chat.ID)
-				return false
-			}
+// resumedUpdate rebuilds the update which executed the command of the pending question.
+// The press of a button cannot be answered anymore, so its callback query has no ID.
+func resumedUpdate(pending *PendingQuestion) tgbotapi.Update {
+	chat := &tgbotapi.Chat{ID: pending.ChatID, Type: pending.ChatType}
+	from := &tgbotapi.User{ID: pending.UserTelegramID, UserName: pending.UserName}
+	if pending.CallbackMessageID != 0 {
+		return tgbotapi.Update{
+			CallbackQuery: &tgbotapi.CallbackQuery{
+				From:    from,
+				Message: &tgbotapi.Message{MessageID: pending.CallbackMessageID, Chat: chat},
+				Data:    pending.CommandText,
+			},
 		}
+	}
+	return tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			MessageID: pending.CommandMessageID,
+			Chat:      chat,
+			From:      from,
+			Text:      pending.CommandText,
+		},
+	}
+}
 
-		if callback, ok := a.AskCallbacks[update.Message.Chat.ID]; ok {
-			a.BotApp.Bot.Send(tgbotapi.NewDeleteMessage(update.Message.Chat.ID, update.Message.MessageID))
-			callback(update.Message.Text, nil)
-			delete(a.AskCallbacks, update.Message.Chat.ID)
-			return true
+// ProcessIncomingMessage passes answers to the pending questions and reports whether the update was consumed.
+func (a *AskService) ProcessIncomingMessage(update tgbotapi.Update) bool {
+	route := a.registry.route(update)
+	if update.CallbackQuery != nil && route.handled {
+		a.BotApp.Messenger.AnswerCallback(update.CallbackQuery.ID, route.callbackText)
+	}
+	if route.ask == nil {
+		return route.handled
+	}
+	if update.Message != nil && route.handled {
+		a.BotApp.Messenger.Delete(update.Message.Chat.ID, update.Message.MessageID)
+	}
+	route.ask.callback(route.answer, route.err)
+	return route.handled
+}
+
+// wait registers the question and blocks until it is answered, times out or the context is done.
+func (a *AskService) wait(ctx context.Context, ask *pendingAsk) (string, error) {
+	type result struct {
+		answer string
+		err    error
+	}
+	retChan := make(chan result, 1)
+	ask.callback = func(answer string, err error) {
+		select {
+		case retChan <- result{answer, err}:
+		default:
 		}
 	}
+	a.registry.add(ask)
+	// the answer is processed by the queue of the chat like any other update,
+	// the command continues once it has been processed
+	reacquire := yieldUpdateWorker(ctx)
+	defer reacquire()
+	timer := time.NewTimer(askTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case res := <-retChan:
+		return res.answer, res.err
+	case <-timer.C:
+		err = errors.New("timed out while waiting for answer")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if !a.registry.remove(ask) {
+		// the answer has just arrived
+		res := <-retChan
+		return res.answer, res.err
+	}
+	return "", err
+}
+
+// AskForArgument asks the user in the chat a question and waits for the answer.
+// Only the answers of that user are accepted, other users in the chat can have their own questions at the same time.
+func (a *AskService) AskForArgument(chatID int64, userID int64, question string, suggestionsArr ...map[string]string) (string, error) {
+	return a.askForArgument(chatID, userID, question, false, suggestionsArr...)
+}
 
-	return false
+// AskForUser asks the user in the chat for another user like AskForArgument. A forwarded message
+// or a shared contact is answered with the ID of its user.
+func (a *AskService) AskForUser(chatID int64, userID int64, question string, suggestionsArr ...map[string]string) (string, error) {
+	return a.askForArgument(chatID, userID, question, true, suggestionsArr...)
 }
 
-func (a *AskService) AskForArgument(chatID int64, question string, suggestionsArr ...map[string]string) (string, error) {
+func (a *AskService) askForArgument(chatID int64, userID int64, question string, userAnswer bool, suggestionsArr ...map[string]string) (string, error) {
+	conversation := a.conversation(askKey{chatID, userID})
+	if answer, ok := a.replayAnswer(conversation); ok {
+		return answer, nil
+	}
 
 	suggestions := map[string]string{}
 	if len(suggestionsArr) != 0 {
@@ -83,7 +348,6 @@ func (a *AskService) AskForArgument(chatID int64, question string, suggestionsAr
 	}
 	extraButtons := [][]tgbotapi.InlineKeyboardButton{}
 	extraButtons = append(extraButtons, []tgbotapi.InlineKeyboardButton{
-		// tgbotapi.NewInlineKeyboardButtonData
 		tgbotapi.NewInlineKeyboardButtonData("❌ Cancel", "/cancel"),
 	})
 	if len(suggestions) > 0 {
@@ -94,83 +358,70 @@ func (a *AskService) AskForArgument(chatID int64, question string, suggestionsAr
 		}
 	}
 	msg := tgbotapi.NewMessage(chatID, question)
-	// msg.ReplyMarkup = tgbotapi.InlineKeyboardMarkup{
-	// 	InlineKeyboard: extraButtons,
-	// }
-
+	// in groups only the user who is asked gets the reply prompt
 	msg.ReplyMarkup = &tgbotapi.ForceReply{
 		ForceReply:            true,
 		InputFieldPlaceholder: question,
+		Selective:             true,
+	}
+	if conversation != nil {
+		msg.ReplyToMessageID = conversation.MessageID
+		msg.AllowSendingWithoutReply = true
 	}
-
-	msg.ReplyToMessageID = 0
 	msg.ParseMode = "HTML"
-	sentMsg, err := a.BotApp.Bot.Send(msg) //sendMsg
+	sentMsg, err := a.BotApp.Messenger.Send(msg)
 	if err != nil {
 		return "", err
 	}
+	a.savePendingQuestion(conversation, PendingQuestionArgument, question, sentMsg.MessageID)
 
+	ask := &pendingAsk{
+		key:        askKey{chatID, userID},
+		messageIDs: []int{sentMsg.MessageID},
+		userAnswer: userAnswer,
+	}
 	if len(suggestions) > 0 {
 		suggMsg := tgbotapi.NewMessage(chatID, "Suggestions:")
 		suggMsg.ReplyMarkup = tgbotapi.InlineKeyboardMarkup{
 			InlineKeyboard: extraButtons,
 		}
-		a.BotApp.Bot.Send(suggMsg)
-	}
-
-	retChan := make(chan interface{})
-	func() {
-		a.AskCallbacksMutex.Lock()
-		defer a.AskCallbacksMutex.Unlock()
-		a.AskCallbacks[chatID] = func(answer string, err error) {
-			if err != nil {
-				select {
-				case retChan <- err:
-				default:
-				}
-				return
-			}
-			select {
-			case retChan <- answer:
-			default:
-
-			}
+		if sentSugg, err := a.BotApp.Messenger.Send(suggMsg); err == nil {
+			ask.messageIDs = append(ask.messageIDs, sentSugg.MessageID)
 		}
-	}()
+	}
 
-	timeout := time.After(time.Second * 60 * 10)
-	select {
-	case answer := <-retChan:
-		switch v := answer.(type) {
-		case string:
-			a.BotApp.Bot.Send(tgbotapi.NewDeleteMessage(chatID, sentMsg.MessageID))
-			msgToSend := tgbotapi.NewMessage(
-				chatID,
-				"<b>"+question+"</b>\n"+v,
-			)
-			msgToSend.ParseMode = "HTML"
-			_, err := a.BotApp.Bot.Send(msgToSend)
-			if err != nil {
-				return "", fmt.Errorf("failed to edit question message: %w", err)
-			}
-			return v, nil
-		case error:
-			a.BotApp.Bot.Send(tgbotapi.NewDeleteMessage(chatID, sentMsg.MessageID))
-			return "", v
-		default:
-			a.BotApp.Bot.Send(tgbotapi.NewDeleteMessage(chatID, sentMsg.MessageID))
-			return "", errors.New("unknown answer type")
-		}
-	case <-timeout:
-		a.AskCallbacksMutex.Lock()
-		defer a.AskCallbacksMutex.Unlock()
-		delete(a.AskCallbacks, chatID)
-		// a.BotApp.Bot.Send(tgbotapi.NewDeleteMessage(chatID, sentMsg.MessageID))
-		return "", errors.New("timed out while waiting for answer")
+	ctx := conversation.context()
+	answer, err := a.wait(ctx, ask)
+	if err != nil && ctx.Err() != nil {
+		// the question stays visible until it is asked again after the restart
+		return "", err
 	}
+	a.BotApp.Messenger.Delete(chatID, sentMsg.MessageID)
+	if err != nil {
+		return "", err
+	}
+	a.recordAnswer(conversation, answer)
+	msgToSend := tgbotapi.NewMessage(
+		chatID,
+		"<b>"+question+"</b>\n"+html.EscapeString(answer),
+	)
+	msgToSend.ParseMode = "HTML"
+	if _, err := a.BotApp.Messenger.Send(msgToSend); err != nil {
+		return "", fmt.Errorf("failed to edit question message: %w", err)
+	}
+	return answer, nil
 }
 
-func (a *AskService) Confirm(chatID int64, question string) error {
+// Confirm asks the user in the chat a yes/no question and returns an error unless it is confirmed.
+func (a *AskService) Confirm(chatID int64, userID int64, question string) error {
+	conversation := a.conversation(askKey{chatID, userID})
+	if answer, ok := a.replayAnswer(conversation); ok {
+		if answer != confirmedAnswer {
+			return errAskCanceled
+		}
+		return nil
+	}
+
 	msg := tgbotapi.NewMessage(chatID, question)
 	msg.ReplyMarkup = tgbotapi.InlineKeyboardMarkup{
 		InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{
@@ -180,49 +431,20 @@ func (a *AskService) Confirm(chatID int64, question string) error {
 			},
 		},
 	}
-
-	msg.ReplyToMessageID = 0
 	msg.ParseMode = "HTML"
-	_, err := a.BotApp.Bot.Send(msg) // sentMsg
+	sentMsg, err := a.BotApp.Messenger.Send(msg)
 	if err != nil {
 		return err
 	}
-	retChan := make(chan interface{})
-	func() {
-		a.AskCallbacksMutex.Lock()
-		defer a.AskCallbacksMutex.Unlock()
-		a.AskCallbacks[chatID] = func(answer string, err error) {
-
-			if err != nil {
-				select {
-				case retChan <- err:
-				default:
-				}
-				return
-			}
-			select {
-			case retChan <- answer:
-			default:
+	a.savePendingQuestion(conversation, PendingQuestionConfirm, question, sentMsg.MessageID)
 
-			}
-		}
-	}()
-	timeout := time.After(time.Second * 60 * 10)
-	select {
-	case answer := <-retChan:
-		switch v := answer.(type) {
-		case string:
-			return nil
-		case error:
-			return v
-		default:
-			return errors.New("unknown answer type")
-		}
-	case <-timeout:
-		a.AskCallbacksMutex.Lock()
-		defer a.AskCallbacksMutex.Unlock()
-		delete(a.AskCallbacks, chatID)
-		return errors.New("timed out while waiting for answer")
+	_, err = a.wait(conversation.context(), &pendingAsk{
+		key:        askKey{chatID, userID},
+		messageIDs: []int{sentMsg.MessageID},
+	})
+	if err != nil {
+		return err
 	}
-
+	a.recordAnswer(conversation, confirmedAnswer)
+	return nil
 }
@@ -0,0 +1,239 @@
+package nighthackbot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestAskServiceAnswersOnlyFromTheAskedUser(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	root := saveTestUser(t, app, &User{TelegramID: 1, Username: "root", IsAdmin: true})
+	alice := saveTestUser(t, app, &User{TelegramID: 2, Username: "alice"})
+	bob := saveTestUser(t, app, &User{TelegramID: 3, Username: "bob"})
+
+	_, done := chat.start(root, "/admin add_admin_user")
+	_, question, err := messenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "Who should be the new admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, question)
+	// alice tries to answer the question of root, which is ignored
+	chat.reply(alice, question, "@alice")
+	chat.reply(root, question, "@bob")
+	waitForDone(t, done)
+
+	for _, user := range []*User{alice, bob} {
+		if err := app.DB.First(user, "id = ?", user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if alice.IsAdmin || !bob.IsAdmin {
+		t.Errorf("expected only bob to be an admin, alice: %v, bob: %v", alice.IsAdmin, bob.IsAdmin)
+	}
+	if _, _, err := messenger.WaitFor(0, time.Second, func(msg FakeMessage) bool {
+		return msg.Kind == FakeMessageDeleted && msg.MessageID == question.MessageID
+	}); err != nil {
+		t.Errorf("expected the question to be deleted: %v", err)
+	}
+}
+
+func TestAskServiceRetryAndConfirm(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	root := saveTestUser(t, app, &User{TelegramID: 1, Username: "root", IsAdmin: true})
+	bob := saveTestUser(t, app, &User{TelegramID: 2, Username: "bob", IsAdmin: true})
+
+	_, done := chat.start(root, "/admin remove_admin_user")
+	i, question, err := messenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "Select admin to remove"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, question)
+	chat.reply(root, question, "not a number")
+	i, question, err = messenger.WaitFor(i+1, 5*time.Second, sentText(testAdminChatID, "is not a number"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, question)
+	chat.press(root, question, fmt.Sprintf("/sugg %d", bob.TelegramID))
+	_, confirm, err := messenger.WaitFor(i+1, 5*time.Second, sentText(testAdminChatID, "Are you sure"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, confirm)
+	chat.press(root, confirm, "/yes")
+	waitForDone(t, done)
+
+	if err := app.DB.First(bob, "id = ?", bob.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bob.IsAdmin {
+		t.Errorf("expected bob not to be an admin anymore")
+	}
+}
+
+// countingCommand counts its executions before asking a question, so it cannot be resumed after a restart.
+type countingCommand struct {
+	StartCommand
+	executions atomic.Int32
+}
+
+func (c *countingCommand) Aliases() []string {
+	return []string{"/count"}
+}
+
+func (c *countingCommand) Execute(ctx context.Context, args *CommandArguments) error {
+	c.executions.Add(1)
+	_, err := args.AskForValue("How many?", StringArgument{})
+	return err
+}
+
+// restartTestBotApp returns a new app using the database of the app, like after a restart.
+func restartTestBotApp(t *testing.T, app *BotApp) (*BotApp, *FakeMessenger) {
+	t.Helper()
+	restarted := NewBotApp()
+	restarted.Config.DB = app.Config.DB
+	if err := restarted.InitDB(); err != nil {
+		t.Fatal(err)
+	}
+	messenger := NewFakeMessenger()
+	restarted.Messenger = messenger
+	restarted.BotName = app.BotName
+	restarted.UpdateQueue = NewUpdateQueue(0, 0, restarted.processUpdate)
+	return restarted, messenger
+}
+
+func TestAskServiceResumesAfterRestart(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	counting := &countingCommand{}
+	app.Commands = append(app.Commands, counting)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	root := saveTestUser(t, app, &User{TelegramID: 1, Username: "root", IsAdmin: true})
+	alice := saveTestUser(t, app, &User{TelegramID: 2, Username: "alice"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	process := func(update tgbotapi.Update) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			app.ProcessUpdate(ctx, update)
+		}()
+	}
+	// root adds a key with a button of the admin menu and answers the first question
+	process(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "callback-100",
+		From:    &tgbotapi.User{ID: root.TelegramID, UserName: root.Username},
+		Message: &tgbotapi.Message{MessageID: 100, Chat: &chat.chat},
+		Data:    "/admin add_key",
+	}})
+	i, question, err := messenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "What kind of key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, question)
+	chat.reply(root, question, string(KeyKindCard))
+	_, question, err = messenger.WaitFor(i+1, 5*time.Second, sentText(testAdminChatID, "Enter a label"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, root.TelegramID, question)
+	// alice runs a command which cannot be resumed
+	process(updateWithMessage(chat.message(alice, "/count")))
+	_, counted, err := messenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "How many"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, alice.TelegramID, counted)
+	cancel()
+	running.Wait()
+
+	restarted, restartedMessenger := restartTestBotApp(t, app)
+	restarted.Commands = append(restarted.Commands, counting)
+	if err := restarted.AskService.ResumePendingQuestions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := restartedMessenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "please run <code>/count</code> again")); err != nil {
+		t.Errorf("expected alice to be asked to run the command again: %v", err)
+	}
+	_, question, err = restartedMessenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "Enter a label"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, restarted, root.TelegramID, question)
+	var pendingQuestions int64
+	if err := restarted.DB.Model(&PendingQuestion{}).Count(&pendingQuestions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if pendingQuestions != 1 {
+		t.Errorf("expected the question of root to be kept until the command finishes, got %d pending questions", pendingQuestions)
+	}
+
+	restartedChat := newFakeChat(restarted, testAdminChatID, "supergroup")
+	restartedChat.nextMessageID = 1000
+	restartedChat.reply(root, question, "front door")
+	if !restarted.UpdateQueue.Wait(5 * time.Second) {
+		t.Fatal("the resumed command did not finish")
+	}
+	if _, _, err := restartedMessenger.WaitFor(0, time.Second, sentText(testAdminChatID, "Added 💳 front door")); err != nil {
+		t.Errorf("expected the resumed button command to reply with a message: %v", err)
+	}
+
+	keys := []Key{}
+	if err := restarted.DB.Find(&keys).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Kind != KeyKindCard || keys[0].Label != "front door" {
+		t.Errorf("expected the key to be added once, got %+v", keys)
+	}
+	var added int64
+	if err := restarted.DB.Model(&AuditLogEntry{}).Where("action = ?", AuditActionKeyAdded).Count(&added).Error; err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Errorf("expected one audit entry of the added key, got %d", added)
+	}
+	if executions := counting.executions.Load(); executions != 1 {
+		t.Errorf("expected the command which cannot be resumed to be executed once, got %d executions", executions)
+	}
+	if err := restarted.DB.Model(&PendingQuestion{}).Count(&pendingQuestions).Error; err != nil {
+		t.Fatal(err)
+	}
+	if pendingQuestions != 0 {
+		t.Errorf("expected the pending questions to be deleted, got %d", pendingQuestions)
+	}
+}
+
+func TestAskServiceEscapesEchoedAnswer(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	chat := newFakeChat(app, testAdminChatID, "supergroup")
+	alice := saveTestUser(t, app, &User{TelegramID: 2, Username: "alice"})
+
+	result := make(chan string, 1)
+	go func() {
+		answer, err := app.AskService.AskForArgument(testAdminChatID, alice.TelegramID, "Label?")
+		if err != nil {
+			t.Error(err)
+		}
+		result <- answer
+	}()
+	i, question, err := messenger.WaitFor(0, 5*time.Second, sentText(testAdminChatID, "Label?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForQuestion(t, app, alice.TelegramID, question)
+	chat.reply(alice, question, "<1 & 2>")
+	if answer := <-result; answer != "<1 & 2>" {
+		t.Errorf("expected the answer as it was written, got %q", answer)
+	}
+	if _, _, err := messenger.WaitFor(i+1, time.Second, sentText(testAdminChatID, "&lt;1 &amp; 2&gt;")); err != nil {
+		t.Errorf("expected the echoed answer to be escaped: %v", err)
+	}
+}
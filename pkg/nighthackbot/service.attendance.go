@@ -0,0 +1,223 @@
+package nighthackbot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// attendanceEarlyCheckIn is how long before the start of a nighthack the users can check in
+const attendanceEarlyCheckIn = time.Hour
+
+// AttendanceService keeps track of who is in the space during a nighthack.
+type AttendanceService struct {
+	BotApp *BotApp
+}
+
+func NewAttendanceService(botApp *BotApp) *AttendanceService {
+	return &AttendanceService{
+		BotApp: botApp,
+	}
+}
+
+// nighthackDuration returns how long the nighthacks last.
+func (s *AttendanceService) nighthackDuration() (time.Duration, error) {
+	return s.BotApp.SettingsService.GetDuration(SettingNighthackDuration)
+}
+
+// RunningNighthack returns the nighthack which the users can check in to, nil if there is none.
+// Only the nighthacks which have been decided to take place can be checked in to.
+func (s *AttendanceService) RunningNighthack(now time.Time) (*Nighthack, error) {
+	duration, err := s.nighthackDuration()
+	if err != nil {
+		return nil, err
+	}
+	nighthack := &Nighthack{}
+	err = s.BotApp.DB.
+		Where("starts_at <= ? AND starts_at > ?", now.Add(attendanceEarlyCheckIn).UTC(), now.Add(-duration).UTC()).
+		Where("forced_decision = ? OR (forced_decision = '' AND decision = ?)", NighthackDecisionOn, NighthackDecisionOn).
+		Order("starts_at").
+		First(nighthack).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nighthack, nil
+}
+
+// checkRunning returns an error unless the users can check in to the nighthack.
+func (s *AttendanceService) checkRunning(nighthack *Nighthack, now time.Time) error {
+	duration, err := s.nighthackDuration()
+	if err != nil {
+		return err
+	}
+	switch {
+	case nighthack.EffectiveDecision() == NighthackDecisionCancelled:
+		return fmt.Errorf("this nighthack has been cancelled")
+	case nighthack.EffectiveDecision() != NighthackDecisionOn:
+		return fmt.Errorf("this nighthack has not been decided yet")
+	case now.Before(nighthack.StartsAt.Add(-attendanceEarlyCheckIn)):
+		return fmt.Errorf("this nighthack starts at %v", s.BotApp.SchedulerService.FormatTime(nighthack.StartsAt))
+	case !now.Before(nighthack.StartsAt.Add(duration)):
+		return fmt.Errorf("this nighthack is over")
+	}
+	return nil
+}
+
+// openAttendance returns the stay of the user which has not been checked out yet, nil if they are not in the space.
+func (s *AttendanceService) openAttendance(nighthack *Nighthack, user *User) (*Attendance, error) {
+	attendance := &Attendance{}
+	err := s.BotApp.DB.Where("nighthack_id = ? AND user_id = ? AND checked_out_at IS NULL", nighthack.ID, user.ID).First(attendance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return attendance, nil
+}
+
+// CheckIn records that the user has arrived at the space and updates the list of the users in the space.
+func (s *AttendanceService) CheckIn(nighthack *Nighthack, user *User, now time.Time) error {
+	if err := s.checkRunning(nighthack, now); err != nil {
+		return err
+	}
+	open, err := s.openAttendance(nighthack, user)
+	if err != nil {
+		return err
+	}
+	if open != nil {
+		return fmt.Errorf("you are already checked in")
+	}
+	attendance := &Attendance{
+		NighthackID: nighthack.ID,
+		UserID:      user.ID,
+		CheckedInAt: now.UTC(),
+	}
+	if err := s.BotApp.DB.Save(attendance).Error; err != nil {
+		return err
+	}
+	return s.UpdateMessage(nighthack, now)
+}
+
+// CheckOut records that the user has left the space and updates the list of the users in the space.
+func (s *AttendanceService) CheckOut(nighthack *Nighthack, user *User, now time.Time) error {
+	open, err := s.openAttendance(nighthack, user)
+	if err != nil {
+		return err
+	}
+	if open == nil {
+		return fmt.Errorf("you are not checked in")
+	}
+	checkedOutAt := now.UTC()
+	open.CheckedOutAt = &checkedOutAt
+	if err := s.BotApp.DB.Save(open).Error; err != nil {
+		return err
+	}
+	return s.UpdateMessage(nighthack, now)
+}
+
+// Present returns the stays of the users who are in the space, in the order they arrived.
+func (s *AttendanceService) Present(nighthack *Nighthack) ([]Attendance, error) {
+	attendances := []Attendance{}
+	err := s.BotApp.DB.Preload("User").
+		Where("nighthack_id = ? AND checked_out_at IS NULL", nighthack.ID).
+		Order("checked_in_at").
+		Find(&attendances).Error
+	return attendances, err
+}
+
+// CloseStale checks out the users who are still checked in to nighthacks which have ended,
+// at the scheduled end of the nighthack.
+func (s *AttendanceService) CloseStale(now time.Time) error {
+	duration, err := s.nighthackDuration()
+	if err != nil {
+		return err
+	}
+	stale := []Attendance{}
+	if err := s.BotApp.DB.Preload("Nighthack").
+		Joins("JOIN nighthacks ON nighthacks.id = attendances.nighthack_id").
+		Where("attendances.checked_out_at IS NULL AND nighthacks.starts_at <= ?", now.Add(-duration).UTC()).
+		Find(&stale).Error; err != nil {
+		return err
+	}
+	ended := map[string]*Nighthack{}
+	for i := range stale {
+		attendance := &stale[i]
+		if attendance.Nighthack == nil {
+			continue
+		}
+		end := attendance.Nighthack.StartsAt.Add(duration).UTC()
+		if end.Before(attendance.CheckedInAt) {
+			end = attendance.CheckedInAt
+		}
+		attendance.CheckedOutAt = &end
+		attendance.AutoClosed = true
+		if err := s.BotApp.DB.Omit("Nighthack").Save(attendance).Error; err != nil {
+			return err
+		}
+		ended[attendance.NighthackID] = attendance.Nighthack
+	}
+	for _, nighthack := range ended {
+		log.Info().Time("starts_at", nighthack.StartsAt).Msgf("Closed the attendance of an ended nighthack")
+		if err := s.UpdateMessage(nighthack, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Message renders the announcement of the nighthack with the users in the space at the time.
+func (s *AttendanceService) Message(nighthack *Nighthack, now time.Time) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	present, err := s.Present(nighthack)
+	if err != nil {
+		return "", nil, err
+	}
+	duration, err := s.nighthackDuration()
+	if err != nil {
+		return "", nil, err
+	}
+	text := "🌙 <b>The nighthack starts now!</b>\n" + html.EscapeString(s.BotApp.SchedulerService.FormatTime(nighthack.StartsAt))
+	if now.After(nighthack.StartsAt.Add(duration)) {
+		return text + "\n\nThe nighthack is over, thanks for coming!", nil, nil
+	}
+	names := []string{}
+	for _, attendance := range present {
+		if attendance.User != nil {
+			names = append(names, html.EscapeString(attendance.User.DisplayName()))
+		}
+	}
+	if len(names) > 0 {
+		text += fmt.Sprintf("\n\n🏠 In the space: %v", strings.Join(names, ", "))
+	} else {
+		text += "\n\n🏠 Nobody has checked in yet"
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🚪 Check in", "/checkin "+nighthack.ID),
+		tgbotapi.NewInlineKeyboardButtonData("👋 Check out", "/checkout "+nighthack.ID),
+	))
+	return text, &markup, nil
+}
+
+// UpdateMessage edits the already sent announcement to show the current users in the space.
+func (s *AttendanceService) UpdateMessage(nighthack *Nighthack, now time.Time) error {
+	if nighthack.AnnouncementMessageID == 0 {
+		return nil
+	}
+	text, markup, err := s.Message(nighthack, now)
+	if err != nil {
+		return err
+	}
+	edit := tgbotapi.NewEditMessageText(nighthack.AnnouncementChatID, nighthack.AnnouncementMessageID, text)
+	edit.ParseMode = "HTML"
+	edit.ReplyMarkup = markup
+	return s.BotApp.Messenger.Edit(edit)
+}
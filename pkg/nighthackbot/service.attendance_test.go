@@ -0,0 +1,129 @@
+package nighthackbot
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttendanceService(t *testing.T) {
+	app := newTestBotApp(t)
+	user := &User{TelegramID: 1, Username: "alice", IsMember: true}
+	if err := app.DB.Save(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	startsAt := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	nighthack := &Nighthack{OccurrenceAt: startsAt, StartsAt: startsAt, Decision: NighthackDecisionOn}
+	if err := app.DB.Save(nighthack).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.AttendanceService.CheckIn(nighthack, user, startsAt.Add(-2*time.Hour)); err == nil {
+		t.Errorf("expected checking in long before the start to fail")
+	}
+	running, err := app.AttendanceService.RunningNighthack(startsAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running == nil || running.ID != nighthack.ID {
+		t.Fatalf("expected the nighthack to be running, got %v", running)
+	}
+
+	if err := app.AttendanceService.CheckIn(nighthack, user, startsAt.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.AttendanceService.CheckIn(nighthack, user, startsAt.Add(time.Hour)); err == nil {
+		t.Errorf("expected checking in twice to fail")
+	}
+	if err := app.AttendanceService.CheckOut(nighthack, user, startsAt.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.AttendanceService.CheckIn(nighthack, user, startsAt.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	present, err := app.AttendanceService.Present(nighthack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(present) != 1 || present[0].User == nil || present[0].User.ID != user.ID {
+		t.Errorf("expected alice to be in the space, got %+v", present)
+	}
+
+	// the default duration is 6h, the stay is closed at the end of the nighthack
+	if err := app.AttendanceService.CloseStale(startsAt.Add(5 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if present, _ := app.AttendanceService.Present(nighthack); len(present) != 1 {
+		t.Errorf("expected the stay to stay open before the end")
+	}
+	if err := app.AttendanceService.CloseStale(startsAt.Add(8 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	attendances := []Attendance{}
+	if err := app.DB.Order("checked_in_at").Find(&attendances).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(attendances) != 2 {
+		t.Fatalf("expected 2 stays, got %d", len(attendances))
+	}
+	closed := attendances[1]
+	if !closed.AutoClosed || closed.CheckedOutAt == nil || !closed.CheckedOutAt.Equal(startsAt.Add(6*time.Hour)) {
+		t.Errorf("expected the stay to be closed at the end of the nighthack, got %+v", closed)
+	}
+	if attendances[0].AutoClosed {
+		t.Errorf("expected the first stay to be checked out by the user")
+	}
+}
+
+func TestAttendanceFollowsTheEffectiveDecision(t *testing.T) {
+	startsAt := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	cases := []struct {
+		forced   NighthackDecision
+		decision NighthackDecision
+		running  bool
+	}{
+		{"", NighthackDecisionOn, true},
+		{"", "", false},
+		{"", NighthackDecisionCancelled, false},
+		{NighthackDecisionOn, NighthackDecisionCancelled, true},
+		{NighthackDecisionOn, "", true},
+		{NighthackDecisionCancelled, NighthackDecisionOn, false},
+	}
+	for _, c := range cases {
+		app := newTestBotApp(t)
+		// a member is not needed to check in
+		user := &User{TelegramID: 1, Username: "alice"}
+		if err := app.DB.Save(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		nighthack := &Nighthack{OccurrenceAt: startsAt, StartsAt: startsAt, ForcedDecision: c.forced, Decision: c.decision}
+		if err := app.DB.Save(nighthack).Error; err != nil {
+			t.Fatal(err)
+		}
+		running, err := app.AttendanceService.RunningNighthack(startsAt.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (running != nil) != c.running {
+			t.Errorf("forced %q, decided %q: expected running to be %v, got %v", c.forced, c.decision, c.running, running)
+		}
+		if err := app.AttendanceService.CheckIn(nighthack, user, startsAt.Add(time.Hour)); (err == nil) != c.running {
+			t.Errorf("forced %q, decided %q: expected checking in to succeed to be %v, got %v", c.forced, c.decision, c.running, err)
+		}
+	}
+}
+
+func TestAttendanceMessageIsOverAfterTheEnd(t *testing.T) {
+	app := newTestBotApp(t)
+	startsAt := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	nighthack := &Nighthack{OccurrenceAt: startsAt, StartsAt: startsAt, Decision: NighthackDecisionOn}
+	if err := app.DB.Save(nighthack).Error; err != nil {
+		t.Fatal(err)
+	}
+	if text, markup, err := app.AttendanceService.Message(nighthack, startsAt.Add(time.Hour)); err != nil || markup == nil || !strings.Contains(text, "Nobody has checked in") {
+		t.Errorf("expected the check in buttons during the nighthack, got %q (%v)", text, err)
+	}
+	if text, markup, err := app.AttendanceService.Message(nighthack, startsAt.Add(7*time.Hour)); err != nil || markup != nil || !strings.Contains(text, "is over") {
+		t.Errorf("expected the nighthack to be over after 6h, got %q (%v)", text, err)
+	}
+}
@@ -0,0 +1,44 @@
+package nighthackbot
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+type AuditService struct {
+	BotApp *BotApp
+}
+
+func NewAuditService(botApp *BotApp) *AuditService {
+	return &AuditService{
+		BotApp: botApp,
+	}
+}
+
+// Record stores the entry and logs it. Failures are only logged, so auditing never blocks the action.
+func (s *AuditService) Record(entry *AuditLogEntry) {
+	log.Info().
+		Str("action", string(entry.Action)).
+		Int64("telegram_id", entry.TelegramID).
+		Int64("chat_id", entry.ChatID).
+		Str("command", entry.Command).
+		Str("details", entry.Details).
+		Msgf("Audit")
+	if err := s.BotApp.DB.Create(entry).Error; err != nil {
+		log.Error().Err(err).Msgf("Failed to save audit log entry")
+	}
+}
+
+// RecordCommand records an event caused by the user executing a command.
+func (s *AuditService) RecordCommand(args *CommandArguments, action AuditAction, details string) {
+	entry := &AuditLogEntry{
+		Action:     action,
+		TelegramID: args.FromUserID,
+		ChatID:     args.ChatID,
+		Command:    args.CommandName,
+		Details:    details,
+	}
+	if args.User != nil && args.User.ID != "" {
+		entry.UserID = &args.User.ID
+	}
+	s.Record(entry)
+}
@@ -0,0 +1,91 @@
+package nighthackbot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	icalPath          = "/nighthacks.ics"
+	updateMetricsPath = "/metrics/updates"
+)
+
+// HTTPService serves the endpoints used outside of Telegram, like the calendar subscription.
+type HTTPService struct {
+	BotApp *BotApp
+	Mux    *http.ServeMux
+
+	server *http.Server
+}
+
+func NewHTTPService(botApp *BotApp) *HTTPService {
+	s := &HTTPService{
+		BotApp: botApp,
+		Mux:    http.NewServeMux(),
+	}
+	s.Mux.HandleFunc(icalPath, s.handleICal)
+	s.Mux.HandleFunc(updateMetricsPath, s.handleUpdateMetrics)
+	return s
+}
+
+// Start starts listening in the background if an address is configured.
+func (s *HTTPService) Start() {
+	listen := s.BotApp.Config.HTTP.Listen
+	if listen == "" {
+		return
+	}
+	s.server = &http.Server{Addr: listen, Handler: s.Mux}
+	go func() {
+		log.Info().Str("listen", listen).Msgf("HTTP server started")
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msgf("HTTP server failed")
+		}
+	}()
+}
+
+// Stop shuts the server down, waiting for the requests being served until the context is done.
+func (s *HTTPService) Stop(ctx context.Context) {
+	if s.server == nil {
+		return
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to shut down HTTP server")
+	}
+}
+
+// URL returns the public URL of the path or an empty string if no public URL is configured.
+func (s *HTTPService) URL(path string) string {
+	if s.BotApp.Config.HTTP.PublicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(s.BotApp.Config.HTTP.PublicURL, "/") + path
+}
+
+func (s *HTTPService) handleICal(w http.ResponseWriter, r *http.Request) {
+	export, err := s.BotApp.SchedulerService.ICalExport(time.Now())
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to export calendar")
+		http.Error(w, "failed to export calendar", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="nighthacks.ics"`)
+	w.Write([]byte(export.Render()))
+}
+
+// handleUpdateMetrics serves the counters of the update queue as JSON.
+func (s *HTTPService) handleUpdateMetrics(w http.ResponseWriter, r *http.Request) {
+	queue := s.BotApp.UpdateQueue
+	if queue == nil {
+		http.Error(w, "not receiving updates", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue.Stats())
+}
@@ -0,0 +1,127 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// KeysService manages the inventory of keys. The IsKeyholder flag of the users is kept in sync with it.
+type KeysService struct {
+	BotApp *BotApp
+}
+
+func NewKeysService(botApp *BotApp) *KeysService {
+	return &KeysService{
+		BotApp: botApp,
+	}
+}
+
+// List returns all the keys with their holders.
+func (s *KeysService) List() ([]Key, error) {
+	keys := []Key{}
+	err := s.BotApp.DB.Preload("Holder").Order("kind, label").Find(&keys).Error
+	return keys, err
+}
+
+// ByLabel returns the key with the label.
+func (s *KeysService) ByLabel(label string) (*Key, error) {
+	key := &Key{}
+	if err := s.BotApp.DB.Preload("Holder").Where("label = ?", label).First(key).Error; err != nil {
+		return nil, fmt.Errorf("failed to find key: %w", err)
+	}
+	return key, nil
+}
+
+// Count returns the number of keys in the inventory.
+func (s *KeysService) Count() (int64, error) {
+	var count int64
+	err := s.BotApp.DB.Model(&Key{}).Count(&count).Error
+	return count, err
+}
+
+// Add registers a new key, which is in the space until it is assigned.
+func (s *KeysService) Add(kind KeyKind, label string, actor *User) (*Key, error) {
+	key := &Key{Kind: kind, Label: label}
+	if err := s.BotApp.DB.Save(key).Error; err != nil {
+		return nil, err
+	}
+	s.audit(actor, AuditActionKeyAdded, fmt.Sprintf("added %v", key))
+	return key, nil
+}
+
+// Assign gives the key to the user, taking it from its previous holder.
+func (s *KeysService) Assign(key *Key, user *User, actor *User) error {
+	previous := key.HolderID
+	now := time.Now()
+	err := s.BotApp.DB.Transaction(func(tx *gorm.DB) error {
+		if user.ID == "" {
+			if err := tx.Save(user).Error; err != nil {
+				return err
+			}
+		}
+		key.HolderID = &user.ID
+		key.Holder = user
+		key.HeldSince = &now
+		if err := tx.Omit("Holder").Save(key).Error; err != nil {
+			return err
+		}
+		if previous != nil {
+			if err := s.syncKeyholder(tx, *previous); err != nil {
+				return err
+			}
+		}
+		return s.syncKeyholder(tx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	user.IsKeyholder = true
+	s.audit(actor, AuditActionKeyAssigned, fmt.Sprintf("gave %v to %v", key, user.DisplayName()))
+	return nil
+}
+
+// Return takes the key back from its holder.
+func (s *KeysService) Return(key *Key, actor *User) error {
+	if key.HolderID == nil {
+		return fmt.Errorf("%v is not held by anyone", key)
+	}
+	previous := *key.HolderID
+	holderName := previous
+	if key.Holder != nil {
+		holderName = key.Holder.DisplayName()
+	}
+	err := s.BotApp.DB.Transaction(func(tx *gorm.DB) error {
+		key.HolderID = nil
+		key.Holder = nil
+		key.HeldSince = nil
+		if err := tx.Omit("Holder").Save(key).Error; err != nil {
+			return err
+		}
+		return s.syncKeyholder(tx, previous)
+	})
+	if err != nil {
+		return err
+	}
+	s.audit(actor, AuditActionKeyReturned, fmt.Sprintf("%v returned %v", holderName, key))
+	return nil
+}
+
+// syncKeyholder makes the user a keyholder if they hold any key.
+func (s *KeysService) syncKeyholder(tx *gorm.DB, userID string) error {
+	var held int64
+	if err := tx.Model(&Key{}).Where("holder_id = ?", userID).Count(&held).Error; err != nil {
+		return err
+	}
+	return tx.Model(&User{}).Where("id = ?", userID).Update("is_keyholder", held > 0).Error
+}
+
+func (s *KeysService) audit(actor *User, action AuditAction, details string) {
+	entry := &AuditLogEntry{Action: action, Details: details}
+	if actor != nil {
+		entry.UserID = &actor.ID
+		entry.TelegramID = actor.TelegramID
+	}
+	s.BotApp.AuditService.Record(entry)
+}
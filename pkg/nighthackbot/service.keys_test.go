@@ -0,0 +1,72 @@
+package nighthackbot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeysServiceKeyholders(t *testing.T) {
+	app := newTestBotApp(t)
+	alice := &User{TelegramID: 1, Username: "alice"}
+	bob := &User{TelegramID: 2, Username: "bob"}
+	for _, user := range []*User{alice, bob} {
+		if err := app.DB.Save(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	isKeyholder := func(user *User) bool {
+		reloaded := &User{}
+		if err := app.DB.First(reloaded, "id = ?", user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return reloaded.IsKeyholder
+	}
+
+	key, err := app.KeysService.Add(KeyKindKey, "front door", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.KeysService.Assign(key, alice, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !isKeyholder(alice) {
+		t.Errorf("expected alice to be a keyholder")
+	}
+
+	// handing the key over moves the keyholder role
+	if err := app.KeysService.Assign(key, bob, nil); err != nil {
+		t.Fatal(err)
+	}
+	if isKeyholder(alice) || !isKeyholder(bob) {
+		t.Errorf("expected only bob to be a keyholder")
+	}
+
+	nighthack := &Nighthack{StartsAt: time.Now().Add(time.Hour), OccurrenceAt: time.Now().Add(time.Hour)}
+	if err := app.DB.Save(nighthack).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*User{alice, bob} {
+		volunteer := &Volunteer{NighthackID: nighthack.ID, UserID: user.ID, Status: VolunteerStatusOpen}
+		if err := app.DB.Save(volunteer).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	quorum, err := app.VolunteerService.EvaluateQuorum(nighthack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quorum.Keyholders != 1 || quorum.Attendees != 2 {
+		t.Errorf("expected only the keyholder to count, got %v", quorum)
+	}
+
+	key, err = app.KeysService.ByLabel("front door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.KeysService.Return(key, nil); err != nil {
+		t.Fatal(err)
+	}
+	if isKeyholder(bob) {
+		t.Errorf("expected bob not to be a keyholder after returning the key")
+	}
+}
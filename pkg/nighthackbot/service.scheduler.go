@@ -0,0 +1,834 @@
+package nighthackbot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// schedulerMaxSleep is the longest the scheduler waits between checks
+	schedulerMaxSleep = time.Minute * 5
+	// announcementGracePeriod is how late a nighthack can still be announced (for example after a restart)
+	announcementGracePeriod = time.Hour
+	// callForVolunteersLookback is how far before a nighthack the call for volunteers is searched for
+	callForVolunteersLookback = time.Hour * 24 * 7
+)
+
+// SchedulerService drives the nighthack lifecycle: it posts the call for volunteers,
+// announces the nighthack when it starts and closes the check-ins when it ends.
+type SchedulerService struct {
+	BotApp *BotApp
+
+	mutex                     sync.Mutex
+	nighthackSchedule         *ScheduleExpression
+	callForVolunteersSchedule *ScheduleExpression
+	location                  *time.Location
+	wake                      chan struct{}
+	// stop ends the loop, which closes done once the current tick has finished
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewSchedulerService(botApp *BotApp) *SchedulerService {
+	return &SchedulerService{
+		BotApp:   botApp,
+		location: time.UTC,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start loads the schedules from the settings and starts the scheduler loop.
+func (s *SchedulerService) Start() error {
+	settings := s.BotApp.SettingsService
+	nighthackSchedule, err := settings.GetScheduleExpression(SettingNighthackSchedule)
+	if err != nil {
+		return fmt.Errorf("failed to load nighthack schedule: %w", err)
+	}
+	callForVolunteersSchedule, err := settings.GetScheduleExpression(SettingCallForVolunteersSchedule)
+	if err != nil {
+		return fmt.Errorf("failed to load call for volunteers schedule: %w", err)
+	}
+	location, err := settings.GetLocation(SettingTimezone)
+	if err != nil {
+		return fmt.Errorf("failed to load time zone: %w", err)
+	}
+	s.mutex.Lock()
+	s.nighthackSchedule = nighthackSchedule
+	s.callForVolunteersSchedule = callForVolunteersSchedule
+	s.location = location
+	s.mutex.Unlock()
+
+	settings.Watch(SettingNighthackSchedule, func(value string) {
+		s.watchSchedule(value, &s.nighthackSchedule)
+	})
+	settings.Watch(SettingCallForVolunteersSchedule, func(value string) {
+		s.watchSchedule(value, &s.callForVolunteersSchedule)
+	})
+	settings.Watch(SettingAnnouncementChatID, func(value string) {
+		s.Wake()
+	})
+	settings.Watch(SettingTimezone, func(value string) {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to load changed time zone")
+			return
+		}
+		s.mutex.Lock()
+		s.location = location
+		s.mutex.Unlock()
+		s.Wake()
+	})
+
+	if chatID, err := s.announcementChatID(); err != nil || chatID == 0 {
+		log.Warn().Msgf("announcement chat is not set, nighthacks will not be announced")
+	}
+	log.Info().
+		Str("nighthack_schedule", scheduleString(nighthackSchedule)).
+		Str("call_for_volunteers_schedule", scheduleString(callForVolunteersSchedule)).
+		Str("timezone", location.String()).
+		Msgf("Scheduler started")
+
+	go s.loop()
+	return nil
+}
+
+// Stop ends the scheduler loop and waits until the actions which are being performed have finished.
+func (s *SchedulerService) Stop() {
+	close(s.stop)
+	<-s.done
+	log.Info().Msgf("Scheduler stopped")
+}
+
+func (s *SchedulerService) watchSchedule(value string, target **ScheduleExpression) {
+	var expr *ScheduleExpression
+	if value != "" {
+		var err error
+		expr, err = ParseScheduleExpression(value)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to parse changed schedule")
+			return
+		}
+	}
+	s.mutex.Lock()
+	*target = expr
+	s.mutex.Unlock()
+	s.Wake()
+}
+
+func (s *SchedulerService) NighthackSchedule() *ScheduleExpression {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.nighthackSchedule
+}
+
+func (s *SchedulerService) CallForVolunteersSchedule() *ScheduleExpression {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.callForVolunteersSchedule
+}
+
+// Location returns the time zone of the space.
+func (s *SchedulerService) Location() *time.Location {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.location
+}
+
+func (s *SchedulerService) SetNighthackSchedule(expr *ScheduleExpression) error {
+	return s.BotApp.SettingsService.Set(SettingNighthackSchedule, scheduleSettingValue(expr))
+}
+
+func (s *SchedulerService) SetCallForVolunteersSchedule(expr *ScheduleExpression) error {
+	return s.BotApp.SettingsService.Set(SettingCallForVolunteersSchedule, scheduleSettingValue(expr))
+}
+
+// Wake makes the scheduler loop re-check its state immediately.
+func (s *SchedulerService) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NextNighthack returns the upcoming nighthack instance, creating it if needed.
+// It returns nil if no nighthack schedule is set or the schedule does not occur anymore.
+func (s *SchedulerService) NextNighthack(now time.Time) (*Nighthack, error) {
+	nighthackSchedule := s.NighthackSchedule()
+	if nighthackSchedule == nil {
+		return nil, nil
+	}
+	occurrence, startsAt, err := s.nextOccurrence(nighthackSchedule, now)
+	if err != nil {
+		return nil, err
+	}
+	if startsAt.IsZero() {
+		return nil, nil
+	}
+	callAt := s.callForVolunteersTime(startsAt)
+
+	// drop instances left over from a previous schedule
+	if err := s.deleteNighthacks(
+		"starts_at > ? AND occurrence_at <> ? AND call_for_volunteers_sent_at IS NULL", now.UTC(), occurrence,
+	); err != nil {
+		return nil, err
+	}
+
+	nighthack := &Nighthack{}
+	err = s.BotApp.DB.Where("occurrence_at = ?", occurrence).First(nighthack).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		nighthack = &Nighthack{
+			OccurrenceAt: occurrence,
+		}
+	}
+	if nighthack.ID != "" && nighthack.StartsAt.Equal(startsAt) &&
+		(nighthack.CallForVolunteersSentAt != nil || nighthack.CallForVolunteersAt.Equal(callAt)) {
+		return nighthack, nil
+	}
+	nighthack.StartsAt = startsAt
+	if nighthack.CallForVolunteersSentAt == nil {
+		nighthack.CallForVolunteersAt = callAt
+	}
+	if err := s.BotApp.DB.Save(nighthack).Error; err != nil {
+		return nil, err
+	}
+	return nighthack, nil
+}
+
+// deleteNighthacks deletes the matching instances with their volunteers. The instances are deleted for good,
+// as the unique occurrence_at would not let them be created again, their decision records are only soft deleted
+// to keep the audit trail.
+func (s *SchedulerService) deleteNighthacks(query interface{}, args ...interface{}) error {
+	return s.BotApp.DB.Transaction(func(tx *gorm.DB) error {
+		ids := []string{}
+		if err := tx.Model(&Nighthack{}).Where(query, args...).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := tx.Where("nighthack_id IN ?", ids).Delete(&DecisionRecord{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("nighthack_id IN ?", ids).Delete(&Volunteer{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&Nighthack{}).Error
+	})
+}
+
+// ScheduledNighthack is an upcoming nighthack computed from the schedule and its exceptions.
+type ScheduledNighthack struct {
+	OccurrenceAt        time.Time
+	StartsAt            time.Time
+	CallForVolunteersAt time.Time // zero if there is no call for volunteers
+	Moved               bool
+	Extra               bool // a one-off nighthack outside of the schedule
+	Forced              bool
+	Cancelled           bool
+	Summary             string // the description of the schedule exception, if any
+}
+
+// nextOccurrence returns the next start of a nighthack after now taking the schedule exceptions into account,
+// together with the occurrence of the schedule it belongs to.
+func (s *SchedulerService) nextOccurrence(schedule *ScheduleExpression, now time.Time) (occurrence time.Time, startsAt time.Time, err error) {
+	scheduled, err := s.scheduledNighthacks(schedule, now, 1)
+	if err != nil || len(scheduled) == 0 {
+		return time.Time{}, time.Time{}, err
+	}
+	return scheduled[0].OccurrenceAt, scheduled[0].StartsAt, nil
+}
+
+// scheduledNighthacks returns up to n nighthacks starting after now, with the moved occurrences in their new place.
+func (s *SchedulerService) scheduledNighthacks(schedule *ScheduleExpression, now time.Time, n int) ([]ScheduledNighthack, error) {
+	exceptions := []ScheduleException{}
+	if err := s.BotApp.DB.
+		Where("occurrence_at > ? OR starts_at > ?", now.UTC(), now.UTC()).
+		Find(&exceptions).Error; err != nil {
+		return nil, err
+	}
+	replaced := map[time.Time]bool{}
+	for _, exception := range exceptions {
+		if exception.Kind != ScheduleExceptionExtra {
+			replaced[exception.OccurrenceAt.UTC()] = true
+		}
+	}
+
+	// every exception removes at most one occurrence, so this is enough to fill n places
+	result := []ScheduledNighthack{}
+	for _, occurrence := range schedule.NextOccurrences(now.In(s.Location()), n+len(exceptions)) {
+		occurrence = occurrence.UTC()
+		if replaced[occurrence] {
+			continue
+		}
+		result = append(result, ScheduledNighthack{OccurrenceAt: occurrence, StartsAt: occurrence})
+	}
+	for _, exception := range exceptions {
+		if exception.Kind == ScheduleExceptionCancel || !exception.StartsAt.After(now) {
+			continue
+		}
+		result = append(result, ScheduledNighthack{
+			OccurrenceAt: exception.OccurrenceAt.UTC(),
+			StartsAt:     exception.StartsAt.UTC(),
+			Moved:        exception.Kind == ScheduleExceptionMove,
+			Extra:        exception.Kind == ScheduleExceptionExtra,
+			Summary:      exception.Summary,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartsAt.Before(result[j].StartsAt)
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result, nil
+}
+
+// UpcomingNighthacks returns the next n nighthacks with their calls for volunteers and the admin overrides.
+func (s *SchedulerService) UpcomingNighthacks(now time.Time, n int) ([]ScheduledNighthack, error) {
+	nighthackSchedule := s.NighthackSchedule()
+	if nighthackSchedule == nil {
+		return nil, nil
+	}
+	scheduled, err := s.scheduledNighthacks(nighthackSchedule, now, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(scheduled) > 0 {
+		// show the skipped occurrences in their place
+		cancelled := []ScheduleException{}
+		if err := s.BotApp.DB.
+			Where("kind = ? AND occurrence_at > ? AND occurrence_at < ?", ScheduleExceptionCancel, now.UTC(), scheduled[len(scheduled)-1].StartsAt.UTC()).
+			Find(&cancelled).Error; err != nil {
+			return nil, err
+		}
+		for _, exception := range cancelled {
+			scheduled = append(scheduled, ScheduledNighthack{
+				OccurrenceAt: exception.OccurrenceAt.UTC(),
+				StartsAt:     exception.OccurrenceAt.UTC(),
+				Cancelled:    true,
+				Summary:      exception.Summary,
+			})
+		}
+		sort.SliceStable(scheduled, func(i, j int) bool {
+			return scheduled[i].StartsAt.Before(scheduled[j].StartsAt)
+		})
+	}
+	occurrences := []time.Time{}
+	for _, nighthack := range scheduled {
+		occurrences = append(occurrences, nighthack.OccurrenceAt)
+	}
+	instances := []Nighthack{}
+	if err := s.BotApp.DB.Where("occurrence_at IN ?", occurrences).Find(&instances).Error; err != nil {
+		return nil, err
+	}
+	for i := range scheduled {
+		nighthack := &scheduled[i]
+		if nighthack.Cancelled {
+			continue
+		}
+		if callAt := s.callForVolunteersTime(nighthack.StartsAt); !callAt.Equal(nighthack.StartsAt) {
+			nighthack.CallForVolunteersAt = callAt
+		}
+		for _, instance := range instances {
+			if !instance.OccurrenceAt.Equal(nighthack.OccurrenceAt) {
+				continue
+			}
+			if instance.CallForVolunteersSentAt != nil {
+				nighthack.CallForVolunteersAt = instance.CallForVolunteersAt
+			}
+			nighthack.Forced = instance.ForcedDecision == NighthackDecisionOn
+			nighthack.Cancelled = instance.ForcedDecision == NighthackDecisionCancelled ||
+				(instance.ForcedDecision == "" && instance.Decision == NighthackDecisionCancelled)
+		}
+	}
+	return scheduled, nil
+}
+
+// ICalExport collects the schedule with its exceptions for the iCalendar export.
+func (s *SchedulerService) ICalExport(now time.Time) (*ICalExport, error) {
+	duration, err := s.BotApp.SettingsService.GetDuration(SettingNighthackDuration)
+	if err != nil {
+		return nil, err
+	}
+	from := now.Add(-icalHistory).UTC()
+	export := &ICalExport{
+		Schedule: s.NighthackSchedule(),
+		Location: s.Location(),
+		Duration: duration,
+		Now:      now,
+	}
+	if err := s.BotApp.DB.
+		Where("occurrence_at > ? OR starts_at > ?", from, from).
+		Find(&export.Exceptions).Error; err != nil {
+		return nil, err
+	}
+	cancelled := []Nighthack{}
+	if err := s.BotApp.DB.
+		Where("starts_at > ? AND (forced_decision = ? OR (forced_decision = '' AND decision = ?))", from, NighthackDecisionCancelled, NighthackDecisionCancelled).
+		Find(&cancelled).Error; err != nil {
+		return nil, err
+	}
+	for _, nighthack := range cancelled {
+		export.Cancelled = append(export.Cancelled, nighthack.StartsAt)
+	}
+	return export, nil
+}
+
+// OverrideNextNighthack moves the next nighthack after now to startsAt without changing the recurring schedule.
+// An exception which already changes that occurrence is updated and becomes one made by hand.
+func (s *SchedulerService) OverrideNextNighthack(startsAt time.Time, user *User, now time.Time) (*Nighthack, error) {
+	nighthack, err := s.NextNighthack(now)
+	if err != nil {
+		return nil, err
+	}
+	if nighthack == nil {
+		return nil, fmt.Errorf("no nighthack is scheduled")
+	}
+	if !startsAt.After(now) {
+		return nil, fmt.Errorf("the new time must be in the future")
+	}
+	exception := &ScheduleException{}
+	err = s.BotApp.DB.Where("occurrence_at = ?", nighthack.OccurrenceAt).First(exception).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// an extra nighthack stays one, it just starts at another time
+	if exception.Kind != ScheduleExceptionExtra {
+		exception.Kind = ScheduleExceptionMove
+	}
+	exception.OccurrenceAt = nighthack.OccurrenceAt
+	exception.StartsAt = startsAt.UTC()
+	exception.UserID = &user.ID
+	// the override was made by hand, so importing a calendar does not remove it
+	exception.SourceUID = ""
+	if err := s.BotApp.DB.Save(exception).Error; err != nil {
+		return nil, err
+	}
+	log.Info().
+		Time("occurrence_at", nighthack.OccurrenceAt).
+		Time("starts_at", exception.StartsAt).
+		Str("user", user.DisplayName()).
+		Msgf("Overrode nighthack time")
+
+	nighthack.StartsAt = exception.StartsAt
+	if nighthack.CallForVolunteersSentAt == nil {
+		nighthack.CallForVolunteersAt = s.callForVolunteersTime(nighthack.StartsAt)
+	}
+	if err := s.BotApp.DB.Save(nighthack).Error; err != nil {
+		return nil, err
+	}
+	if nighthack.CallForVolunteersSentAt != nil {
+		if err := s.BotApp.VolunteerService.UpdateCallMessage(nighthack); err != nil {
+			log.Error().Err(err).Msgf("Failed to update call for volunteers message")
+		}
+		chatID, err := s.announcementChatID()
+		if err != nil {
+			return nil, err
+		}
+		if chatID != 0 {
+			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("🕑 The next nighthack has been moved to <b>%v</b>", s.FormatTime(nighthack.StartsAt)))
+			msg.ParseMode = "HTML"
+			if _, err := s.BotApp.Messenger.Send(msg); err != nil {
+				return nil, err
+			}
+		}
+	}
+	s.Wake()
+	return nighthack, nil
+}
+
+// ApplyICalImport saves the changes of an imported calendar and updates the affected nighthack instances.
+func (s *SchedulerService) ApplyICalImport(plan *ICalImportPlan, user *User) error {
+	err := s.BotApp.DB.Transaction(func(tx *gorm.DB) error {
+		for _, change := range plan.Changes {
+			if change.Remove {
+				if err := tx.Unscoped().Delete(change.Previous).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			exception := change.Exception
+			if change.Previous != nil {
+				exception.ID = change.Previous.ID
+				exception.CreatedAt = change.Previous.CreatedAt
+			}
+			exception.UserID = &user.ID
+			if err := tx.Save(&exception).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Int("changes", len(plan.Changes)).
+		Str("user", user.DisplayName()).
+		Msgf("Imported calendar")
+
+	for _, change := range plan.Changes {
+		if err := s.syncInstance(change); err != nil {
+			log.Error().Err(err).Time("occurrence_at", change.Exception.OccurrenceAt).Msgf("Failed to update nighthack after import")
+		}
+	}
+	s.Wake()
+	return nil
+}
+
+// syncInstance updates the nighthack instance affected by an imported change.
+// Instances without a call for volunteers are dropped and created again by the scheduler.
+func (s *SchedulerService) syncInstance(change ICalImportChange) error {
+	nighthack := &Nighthack{}
+	err := s.BotApp.DB.Where("occurrence_at = ?", change.Exception.OccurrenceAt).First(nighthack).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if nighthack.CallForVolunteersSentAt == nil {
+		return s.deleteNighthacks("id = ?", nighthack.ID)
+	}
+	if !nighthack.StartsAt.After(time.Now()) {
+		return nil
+	}
+	switch {
+	case change.Remove:
+		if change.Exception.Kind != ScheduleExceptionMove {
+			return nil
+		}
+		nighthack.StartsAt = nighthack.OccurrenceAt
+	case change.Exception.Kind == ScheduleExceptionCancel:
+		if nighthack.ForcedDecision == NighthackDecisionCancelled {
+			return nil
+		}
+		nighthack.ForcedDecision = NighthackDecisionCancelled
+		nighthack.DecidedAt = nil
+		nighthack.Decision = ""
+	default:
+		nighthack.StartsAt = change.Exception.StartsAt
+	}
+	if err := s.BotApp.DB.Save(nighthack).Error; err != nil {
+		return err
+	}
+	return s.BotApp.VolunteerService.UpdateCallMessage(nighthack)
+}
+
+// callForVolunteersTime returns the last occurrence of the call for volunteers schedule before startsAt.
+// If there is none the call is made at startsAt, which means it is never sent.
+func (s *SchedulerService) callForVolunteersTime(startsAt time.Time) time.Time {
+	callForVolunteersSchedule := s.CallForVolunteersSchedule()
+	if callForVolunteersSchedule == nil {
+		return startsAt
+	}
+	result := startsAt
+	t := startsAt.Add(-callForVolunteersLookback).In(s.Location())
+	for {
+		t = callForVolunteersSchedule.GetNextOccurence(t)
+		if t.IsZero() || !t.Before(startsAt) {
+			break
+		}
+		result = t
+	}
+	return result.UTC()
+}
+
+func (s *SchedulerService) loop() {
+	defer close(s.done)
+	for {
+		now := time.Now()
+		sleep := schedulerMaxSleep
+		next, err := s.tick(now)
+		if err != nil {
+			log.Error().Err(err).Msgf("Scheduler tick failed")
+		}
+		if !next.IsZero() && next.Sub(now) < sleep {
+			sleep = next.Sub(now)
+		}
+		select {
+		case <-time.After(sleep):
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// tick performs all the actions which are due and returns the time of the next one.
+// An action which fails does not block the others, they are all retried on the next tick.
+// The failures are returned together, for the caller to log.
+func (s *SchedulerService) tick(now time.Time) (time.Time, error) {
+	nighthack, err := s.NextNighthack(now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get next nighthack: %w", err)
+	}
+	if err := s.BotApp.AttendanceService.CloseStale(now); err != nil {
+		return time.Time{}, fmt.Errorf("failed to close stale check-ins: %w", err)
+	}
+	chatID, err := s.announcementChatID()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get announcement chat: %w", err)
+	}
+	if chatID == 0 {
+		return time.Time{}, nil
+	}
+
+	dueCalls := []Nighthack{}
+	if err := s.BotApp.DB.
+		Where("call_for_volunteers_sent_at IS NULL AND call_for_volunteers_at <= ? AND starts_at > ?", now.UTC(), now.UTC()).
+		Find(&dueCalls).Error; err != nil {
+		return time.Time{}, err
+	}
+	failures := []error{}
+	for i := range dueCalls {
+		if err := s.sendCallForVolunteers(chatID, &dueCalls[i], now); err != nil {
+			failures = append(failures, fmt.Errorf("failed to send call for volunteers of %v: %w", dueCalls[i].StartsAt.UTC(), err))
+		}
+	}
+
+	cutoff, err := s.BotApp.SettingsService.GetDuration(SettingDecisionCutoff)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get decision cutoff: %w", err)
+	}
+	dueDecisions := []Nighthack{}
+	if err := s.BotApp.DB.
+		Where("decided_at IS NULL AND starts_at <= ? AND starts_at > ?", now.Add(cutoff).UTC(), now.Add(-announcementGracePeriod).UTC()).
+		Find(&dueDecisions).Error; err != nil {
+		return time.Time{}, err
+	}
+	for i := range dueDecisions {
+		if err := s.decide(chatID, &dueDecisions[i], now); err != nil {
+			failures = append(failures, fmt.Errorf("failed to decide about nighthack of %v: %w", dueDecisions[i].StartsAt.UTC(), err))
+		}
+	}
+
+	dueAnnouncements := []Nighthack{}
+	if err := s.BotApp.DB.
+		Where("announced_at IS NULL AND decision = ? AND starts_at <= ? AND starts_at > ?", NighthackDecisionOn, now.UTC(), now.Add(-announcementGracePeriod).UTC()).
+		Find(&dueAnnouncements).Error; err != nil {
+		return time.Time{}, err
+	}
+	for i := range dueAnnouncements {
+		if err := s.announce(chatID, &dueAnnouncements[i], now); err != nil {
+			failures = append(failures, fmt.Errorf("failed to announce nighthack of %v: %w", dueAnnouncements[i].StartsAt.UTC(), err))
+		}
+	}
+	err = combineErrors(failures)
+
+	if nighthack == nil {
+		return time.Time{}, err
+	}
+	if nighthack.CallForVolunteersSentAt == nil && nighthack.CallForVolunteersAt.After(now) {
+		return nighthack.CallForVolunteersAt, err
+	}
+	if nighthack.DecidedAt == nil && nighthack.StartsAt.Add(-cutoff).After(now) {
+		return nighthack.StartsAt.Add(-cutoff), err
+	}
+	return nighthack.StartsAt, err
+}
+
+// combineErrors returns nil for no errors, the error itself for one and a summary wrapping the first one otherwise.
+func combineErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	messages := []string{}
+	for _, err := range errs[1:] {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Errorf("%w (and %d more: %v)", errs[0], len(errs)-1, strings.Join(messages, "; "))
+}
+
+// decide evaluates the quorum (or the admin override) of the nighthack and posts the decision.
+func (s *SchedulerService) decide(chatID int64, nighthack *Nighthack, now time.Time) error {
+	quorum, err := s.BotApp.VolunteerService.EvaluateQuorum(nighthack)
+	if err != nil {
+		return err
+	}
+	record := &DecisionRecord{
+		NighthackID: nighthack.ID,
+		Keyholders:  quorum.Keyholders,
+		Attendees:   quorum.Attendees,
+	}
+	var text string
+	switch {
+	case nighthack.ForcedDecision != "":
+		record.Decision = nighthack.ForcedDecision
+		record.Source = DecisionSourceAdmin
+		record.Reason = "forced by an admin"
+		if record.Decision == NighthackDecisionOn {
+			text = "✅ <b>Nighthack is ON!</b>"
+		} else {
+			text = "🚫 <b>Nighthack cancelled</b> by the admins"
+		}
+	case quorum.Reached():
+		record.Decision = NighthackDecisionOn
+		record.Source = DecisionSourceQuorum
+		record.Reason = "quorum reached: " + quorum.String()
+		text = "✅ <b>Nighthack is ON!</b>"
+	default:
+		record.Decision = NighthackDecisionCancelled
+		record.Source = DecisionSourceQuorum
+		record.Reason = "quorum not reached: " + quorum.String()
+		text = "🚫 <b>Nighthack cancelled</b> – nobody volunteered"
+	}
+	text += fmt.Sprintf("\n%v", s.FormatTime(nighthack.StartsAt))
+
+	// the decision is only saved once it has been announced, otherwise it is made again on the next tick
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	if _, err := s.BotApp.Messenger.Send(msg); err != nil {
+		return err
+	}
+	decidedAt := now.UTC()
+	err = s.BotApp.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		nighthack.Decision = record.Decision
+		nighthack.DecidedAt = &decidedAt
+		return tx.Save(nighthack).Error
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Time("starts_at", nighthack.StartsAt).
+		Str("decision", string(record.Decision)).
+		Str("reason", record.Reason).
+		Msgf("Decided about nighthack")
+	return nil
+}
+
+// ForceDecision overrides the quorum decision of the nighthack with the ID, unless it has already started.
+// If the nighthack has already been decided differently, the decision is made again.
+func (s *SchedulerService) ForceDecision(nighthackID string, decision NighthackDecision, user *User, now time.Time) (*Nighthack, error) {
+	nighthack := &Nighthack{}
+	if err := s.BotApp.DB.Where("id = ?", nighthackID).First(nighthack).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("the nighthack is no longer scheduled")
+		}
+		return nil, err
+	}
+	if !nighthack.StartsAt.After(now) {
+		return nil, fmt.Errorf("the nighthack has already started")
+	}
+	nighthack.ForcedDecision = decision
+	if nighthack.DecidedAt != nil && nighthack.Decision != decision {
+		nighthack.DecidedAt = nil
+		nighthack.Decision = ""
+	}
+	if err := s.BotApp.DB.Save(nighthack).Error; err != nil {
+		return nil, err
+	}
+	record := &DecisionRecord{
+		NighthackID: nighthack.ID,
+		Decision:    decision,
+		Source:      DecisionSourceAdmin,
+		Reason:      "override requested by " + user.DisplayName(),
+		UserID:      &user.ID,
+	}
+	if err := s.BotApp.DB.Create(record).Error; err != nil {
+		return nil, err
+	}
+	s.Wake()
+	return nighthack, nil
+}
+
+func (s *SchedulerService) sendCallForVolunteers(chatID int64, nighthack *Nighthack, now time.Time) error {
+	text, markup, err := s.BotApp.VolunteerService.CallMessage(nighthack)
+	if err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = markup
+	sentMsg, err := s.BotApp.Messenger.Send(msg)
+	if err != nil {
+		return err
+	}
+	sentAt := now.UTC()
+	nighthack.CallForVolunteersSentAt = &sentAt
+	nighthack.CallMessageChatID = sentMsg.Chat.ID
+	nighthack.CallMessageID = sentMsg.MessageID
+	log.Info().Time("starts_at", nighthack.StartsAt).Msgf("Sent call for volunteers")
+	return s.BotApp.DB.Save(nighthack).Error
+}
+
+// announce posts the announcement of the nighthack, which lists the users who have checked in.
+func (s *SchedulerService) announce(chatID int64, nighthack *Nighthack, now time.Time) error {
+	text, markup, err := s.BotApp.AttendanceService.Message(nighthack, now)
+	if err != nil {
+		return err
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	sentMsg, err := s.BotApp.Messenger.Send(msg)
+	if err != nil {
+		return err
+	}
+	announcedAt := now.UTC()
+	nighthack.AnnouncedAt = &announcedAt
+	nighthack.AnnouncementChatID = sentMsg.Chat.ID
+	nighthack.AnnouncementMessageID = sentMsg.MessageID
+	log.Info().Time("starts_at", nighthack.StartsAt).Msgf("Announced nighthack")
+	return s.BotApp.DB.Save(nighthack).Error
+}
+
+func (s *SchedulerService) announcementChatID() (int64, error) {
+	return s.BotApp.SettingsService.GetInt64(SettingAnnouncementChatID)
+}
+
+const nighthackTimeInputLayout = "2006-01-02 15:04"
+
+// FormatTime formats a time for humans in the time zone of the space.
+func (s *SchedulerService) FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.In(s.Location()).Format("Monday 02.01.2006 15:04")
+}
+
+// ParseTime parses a date and time entered by an admin in the time zone of the space.
+func (s *SchedulerService) ParseTime(src string) (time.Time, error) {
+	t, err := time.ParseInLocation(nighthackTimeInputLayout, strings.TrimSpace(src), s.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected date and time like %v", nighthackTimeInputLayout)
+	}
+	return t, nil
+}
+
+// scheduleString renders a possibly unset schedule for humans.
+func scheduleString(expr *ScheduleExpression) string {
+	if expr == nil {
+		return "not set"
+	}
+	return expr.String()
+}
+
+func scheduleSettingValue(expr *ScheduleExpression) string {
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
@@ -0,0 +1,474 @@
+package nighthackbot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAnnouncementChatID = -200
+
+// newSchedulerTestApp returns a fake app posting its announcements to testAnnouncementChatID.
+func newSchedulerTestApp(t *testing.T) (*BotApp, *FakeMessenger) {
+	t.Helper()
+	app, messenger := newFakeBotApp(t)
+	if err := app.SettingsService.Set(SettingAnnouncementChatID, "-200"); err != nil {
+		t.Fatal(err)
+	}
+	return app, messenger
+}
+
+func saveTestNighthack(t *testing.T, app *BotApp, nighthack *Nighthack) *Nighthack {
+	t.Helper()
+	if nighthack.OccurrenceAt.IsZero() {
+		nighthack.OccurrenceAt = nighthack.StartsAt
+	}
+	if err := app.DB.Save(nighthack).Error; err != nil {
+		t.Fatal(err)
+	}
+	return nighthack
+}
+
+func TestSchedulerTickContinuesAfterFailedSend(t *testing.T) {
+	app, messenger := newSchedulerTestApp(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	failing := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(48 * time.Hour), CallForVolunteersAt: now.Add(-time.Minute)})
+	working := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(72 * time.Hour), CallForVolunteersAt: now.Add(-time.Minute)})
+	calledAt := now.Add(-24 * time.Hour)
+	deciding := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(time.Hour), CallForVolunteersAt: calledAt, CallForVolunteersSentAt: &calledAt})
+	failingTime := app.SchedulerService.FormatTime(failing.StartsAt)
+	messenger.SendError = func(msg FakeMessage) error {
+		if strings.Contains(msg.Text, failingTime) {
+			return errors.New("bot was kicked")
+		}
+		return nil
+	}
+
+	if _, err := app.SchedulerService.tick(now); err == nil || !strings.Contains(err.Error(), "bot was kicked") {
+		t.Errorf("expected the failed send to be reported, got %v", err)
+	}
+
+	reload := func(nighthack *Nighthack) *Nighthack {
+		result := &Nighthack{}
+		if err := app.DB.First(result, "id = ?", nighthack.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	if reload(failing).CallForVolunteersSentAt != nil {
+		t.Errorf("expected the failed call to stay unsent")
+	}
+	if reload(working).CallForVolunteersSentAt == nil {
+		t.Errorf("expected the other call to be sent after the failed one")
+	}
+	// nobody volunteered, so the nighthack within the cutoff is cancelled
+	if decided := reload(deciding); decided.DecidedAt == nil || decided.Decision != NighthackDecisionCancelled {
+		t.Errorf("expected the decision to be made after the failed call, got %+v", decided)
+	}
+	if sent := messenger.Sent(testAnnouncementChatID); len(sent) != 2 {
+		t.Errorf("expected a call and a decision to be posted, got %d messages", len(sent))
+	}
+
+	// the failed call is retried on the next tick and nothing else is sent again
+	messenger.SendError = nil
+	if _, err := app.SchedulerService.tick(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if reload(failing).CallForVolunteersSentAt == nil {
+		t.Errorf("expected the failed call to be retried")
+	}
+	if sent := messenger.Sent(testAnnouncementChatID); len(sent) != 3 {
+		t.Errorf("expected only the retried call to be posted, got %d messages", len(sent))
+	}
+}
+
+func TestSchedulerDecide(t *testing.T) {
+	type volunteer struct {
+		status    VolunteerStatus
+		keyholder bool
+	}
+	cases := []struct {
+		name               string
+		keyholderQuorum    string
+		volunteerQuorum    string
+		keyRegistry        bool
+		volunteers         []volunteer
+		forced             NighthackDecision
+		expectedDecision   NighthackDecision
+		expectedSource     DecisionSource
+		expectedKeyholders int
+		expectedAttendees  int
+		expectedText       string
+	}{
+		{
+			name:             "nobody volunteered",
+			expectedDecision: NighthackDecisionCancelled,
+			expectedSource:   DecisionSourceQuorum,
+			expectedText:     "nobody volunteered",
+		},
+		{
+			name:               "a volunteer who can open",
+			volunteers:         []volunteer{{status: VolunteerStatusOpen}},
+			expectedDecision:   NighthackDecisionOn,
+			expectedSource:     DecisionSourceQuorum,
+			expectedKeyholders: 1,
+			expectedAttendees:  1,
+			expectedText:       "Nighthack is ON",
+		},
+		{
+			name:              "attendees without anyone to open",
+			volunteers:        []volunteer{{status: VolunteerStatusAttend}, {status: VolunteerStatusAttend}},
+			expectedDecision:  NighthackDecisionCancelled,
+			expectedSource:    DecisionSourceQuorum,
+			expectedAttendees: 2,
+			expectedText:      "Nighthack cancelled",
+		},
+		{
+			name:               "volunteer quorum not reached",
+			volunteerQuorum:    "3",
+			volunteers:         []volunteer{{status: VolunteerStatusOpen}, {status: VolunteerStatusAttend}, {status: VolunteerStatusNo}},
+			expectedDecision:   NighthackDecisionCancelled,
+			expectedSource:     DecisionSourceQuorum,
+			expectedKeyholders: 1,
+			expectedAttendees:  2,
+		},
+		{
+			name:               "volunteer quorum reached",
+			volunteerQuorum:    "3",
+			volunteers:         []volunteer{{status: VolunteerStatusOpen}, {status: VolunteerStatusAttend}, {status: VolunteerStatusAttend}},
+			expectedDecision:   NighthackDecisionOn,
+			expectedSource:     DecisionSourceQuorum,
+			expectedKeyholders: 1,
+			expectedAttendees:  3,
+		},
+		{
+			name:              "only keyholders open once keys are registered",
+			keyRegistry:       true,
+			volunteers:        []volunteer{{status: VolunteerStatusOpen}},
+			expectedDecision:  NighthackDecisionCancelled,
+			expectedSource:    DecisionSourceQuorum,
+			expectedAttendees: 1,
+		},
+		{
+			name:               "keyholder quorum of two",
+			keyholderQuorum:    "2",
+			keyRegistry:        true,
+			volunteers:         []volunteer{{status: VolunteerStatusOpen, keyholder: true}, {status: VolunteerStatusOpen, keyholder: true}},
+			expectedDecision:   NighthackDecisionOn,
+			expectedSource:     DecisionSourceQuorum,
+			expectedKeyholders: 2,
+			expectedAttendees:  2,
+		},
+		{
+			name:             "forced on without volunteers",
+			forced:           NighthackDecisionOn,
+			expectedDecision: NighthackDecisionOn,
+			expectedSource:   DecisionSourceAdmin,
+			expectedText:     "Nighthack is ON",
+		},
+		{
+			name:               "forced cancel despite the quorum",
+			forced:             NighthackDecisionCancelled,
+			volunteers:         []volunteer{{status: VolunteerStatusOpen}},
+			expectedDecision:   NighthackDecisionCancelled,
+			expectedSource:     DecisionSourceAdmin,
+			expectedKeyholders: 1,
+			expectedAttendees:  1,
+			expectedText:       "by the admins",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			app, messenger := newSchedulerTestApp(t)
+			if c.keyholderQuorum != "" {
+				if err := app.SettingsService.Set(SettingKeyholderQuorum, c.keyholderQuorum); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.volunteerQuorum != "" {
+				if err := app.SettingsService.Set(SettingVolunteerQuorum, c.volunteerQuorum); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if c.keyRegistry {
+				if err := app.DB.Create(&Key{Kind: KeyKindKey, Label: "front door"}).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+			now := time.Date(2024, 3, 1, 17, 0, 0, 0, time.UTC)
+			nighthack := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(time.Hour), ForcedDecision: c.forced})
+			for i, v := range c.volunteers {
+				user := saveTestUser(t, app, &User{TelegramID: int64(i + 1), IsKeyholder: v.keyholder})
+				if err := app.VolunteerService.SetStatus(nighthack, user, v.status); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := app.SchedulerService.decide(testAnnouncementChatID, nighthack, now); err != nil {
+				t.Fatal(err)
+			}
+			saved := &Nighthack{}
+			if err := app.DB.First(saved, "id = ?", nighthack.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if saved.Decision != c.expectedDecision || saved.DecidedAt == nil || !saved.DecidedAt.Equal(now) {
+				t.Errorf("expected the nighthack to be decided %v, got %v at %v", c.expectedDecision, saved.Decision, saved.DecidedAt)
+			}
+			records := []DecisionRecord{}
+			if err := app.DB.Where("nighthack_id = ?", nighthack.ID).Find(&records).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("expected one decision record, got %d", len(records))
+			}
+			record := records[0]
+			if record.Decision != c.expectedDecision || record.Source != c.expectedSource ||
+				record.Keyholders != c.expectedKeyholders || record.Attendees != c.expectedAttendees {
+				t.Errorf("unexpected decision record %+v", record)
+			}
+			sent := messenger.Sent(testAnnouncementChatID)
+			if len(sent) != 1 || !strings.Contains(sent[0].Text, c.expectedText) {
+				t.Errorf("expected the decision to be posted with %q, got %+v", c.expectedText, sent)
+			}
+		})
+	}
+}
+
+func TestSchedulerDecidesAtTheCutoff(t *testing.T) {
+	app, messenger := newSchedulerTestApp(t)
+	if err := app.SettingsService.Set(SettingDecisionCutoff, "3h"); err != nil {
+		t.Fatal(err)
+	}
+	startsAt := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	sentAt := startsAt.Add(-48 * time.Hour)
+	nighthack := saveTestNighthack(t, app, &Nighthack{StartsAt: startsAt, CallForVolunteersAt: sentAt, CallForVolunteersSentAt: &sentAt})
+	decidedAt := func() *time.Time {
+		saved := &Nighthack{}
+		if err := app.DB.First(saved, "id = ?", nighthack.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return saved.DecidedAt
+	}
+
+	if _, err := app.SchedulerService.tick(startsAt.Add(-3*time.Hour - time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if decidedAt() != nil || len(messenger.Sent(testAnnouncementChatID)) != 0 {
+		t.Errorf("expected no decision before the cutoff")
+	}
+	if _, err := app.SchedulerService.tick(startsAt.Add(-3 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if decidedAt() == nil {
+		t.Errorf("expected the decision at the cutoff")
+	}
+	if _, err := app.SchedulerService.tick(startsAt.Add(-2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if sent := messenger.Sent(testAnnouncementChatID); len(sent) != 1 {
+		t.Errorf("expected the decision to be posted once, got %d messages", len(sent))
+	}
+}
+
+func TestNextNighthackDropsLeftoverInstances(t *testing.T) {
+	app := newTestBotApp(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	leftover := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(24 * time.Hour), CallForVolunteersAt: now.Add(time.Hour)})
+	user := saveTestUser(t, app, &User{TelegramID: 1})
+	if err := app.DB.Create(&DecisionRecord{NighthackID: leftover.ID, Decision: NighthackDecisionOn, Source: DecisionSourceAdmin, UserID: &user.ID}).Error; err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := ParseScheduleExpression("friday 18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SchedulerService.nighthackSchedule = schedule
+
+	next, err := app.SchedulerService.NextNighthack(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.ID == leftover.ID {
+		t.Fatalf("expected a new instance for the schedule, got %+v", next)
+	}
+	var count int64
+	if err := app.DB.Unscoped().Model(&Nighthack{}).Where("id = ?", leftover.ID).Count(&count).Error; err != nil || count != 0 {
+		t.Errorf("expected the leftover instance to be deleted, got %d (%v)", count, err)
+	}
+	records := []DecisionRecord{}
+	if err := app.DB.Unscoped().Where("nighthack_id = ?", leftover.ID).Find(&records).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].DeletedAt.Valid || records[0].UserID == nil || *records[0].UserID != user.ID {
+		t.Errorf("expected the decision record of the leftover instance to be kept soft deleted, got %+v", records)
+	}
+}
+
+func TestSchedulerDecideRetriesFailedAnnouncement(t *testing.T) {
+	app, messenger := newSchedulerTestApp(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	nighthack := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(time.Hour), ForcedDecision: NighthackDecisionOn})
+	messenger.SendError = func(msg FakeMessage) error {
+		return errors.New("bot was kicked")
+	}
+	if err := app.SchedulerService.decide(testAnnouncementChatID, nighthack, now); err == nil {
+		t.Fatal("expected the failed announcement to be reported")
+	}
+	reloaded := &Nighthack{}
+	if err := app.DB.First(reloaded, "id = ?", nighthack.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.DecidedAt != nil {
+		t.Errorf("expected the nighthack to stay undecided until the decision is announced")
+	}
+	var records int64
+	if err := app.DB.Model(&DecisionRecord{}).Count(&records).Error; err != nil || records != 0 {
+		t.Errorf("expected no decision to be recorded, got %d (%v)", records, err)
+	}
+
+	messenger.SendError = nil
+	if err := app.SchedulerService.decide(testAnnouncementChatID, reloaded, now); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.DecidedAt == nil || reloaded.Decision != NighthackDecisionOn {
+		t.Errorf("expected the retried decision to be saved, got %+v", reloaded)
+	}
+	if sent := messenger.Sent(testAnnouncementChatID); len(sent) != 1 {
+		t.Errorf("expected the decision to be posted once, got %d messages", len(sent))
+	}
+}
+
+func TestForceDecisionOfTheConfirmedNighthack(t *testing.T) {
+	app, _ := newSchedulerTestApp(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	confirmed := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(48 * time.Hour)})
+	// an earlier nighthack which would be the next one now
+	earlier := saveTestNighthack(t, app, &Nighthack{StartsAt: now.Add(24 * time.Hour)})
+	admin := saveTestUser(t, app, &User{TelegramID: 1, IsAdmin: true})
+
+	if _, err := app.SchedulerService.ForceDecision(confirmed.ID, NighthackDecisionCancelled, admin, now); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []*Nighthack{confirmed, earlier} {
+		if err := app.DB.First(n, "id = ?", n.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if confirmed.ForcedDecision != NighthackDecisionCancelled || earlier.ForcedDecision != "" {
+		t.Errorf("expected only the confirmed nighthack to be forced, got %q and %q", confirmed.ForcedDecision, earlier.ForcedDecision)
+	}
+	if _, err := app.SchedulerService.ForceDecision(confirmed.ID, NighthackDecisionOn, admin, confirmed.StartsAt); err == nil {
+		t.Errorf("expected a started nighthack not to be forced")
+	}
+	if _, err := app.SchedulerService.ForceDecision("gone", NighthackDecisionOn, admin, now); err == nil {
+		t.Errorf("expected a deleted nighthack not to be forced")
+	}
+}
+
+func TestOverrideNextNighthack(t *testing.T) {
+	// Wednesday, the next nighthack is on Friday 2024-03-01 18:00 UTC
+	now := time.Date(2024, 2, 28, 12, 0, 0, 0, time.UTC)
+	occurrence := time.Date(2024, 3, 1, 18, 0, 0, 0, time.UTC)
+	schedule, err := ParseScheduleExpression("friday 18:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup := func(t *testing.T, existing *ScheduleException) (*BotApp, *User) {
+		app, _ := newSchedulerTestApp(t)
+		app.SchedulerService.nighthackSchedule = schedule
+		if existing != nil {
+			if err := app.DB.Create(existing).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		return app, saveTestUser(t, app, &User{TelegramID: 1, Username: "admin", IsAdmin: true})
+	}
+	exceptions := func(t *testing.T, app *BotApp) []ScheduleException {
+		result := []ScheduleException{}
+		if err := app.DB.Find(&result).Error; err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("moves the occurrence", func(t *testing.T) {
+		app, admin := setup(t, nil)
+		nighthack, err := app.SchedulerService.OverrideNextNighthack(occurrence.Add(2*time.Hour), admin, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nighthack.OccurrenceAt.Equal(occurrence) || !nighthack.StartsAt.Equal(occurrence.Add(2*time.Hour)) {
+			t.Errorf("expected the occurrence to be moved by 2h, got %+v", nighthack)
+		}
+		saved := exceptions(t, app)
+		if len(saved) != 1 || saved[0].Kind != ScheduleExceptionMove || saved[0].SourceUID != "" || saved[0].UserID == nil || *saved[0].UserID != admin.ID {
+			t.Errorf("expected a move made by the admin, got %+v", saved)
+		}
+		if _, err := app.SchedulerService.OverrideNextNighthack(now.Add(-time.Hour), admin, now); err == nil {
+			t.Errorf("expected moving the nighthack into the past to fail")
+		}
+	})
+
+	t.Run("replaces an imported exception of the occurrence", func(t *testing.T) {
+		app, admin := setup(t, &ScheduleException{
+			Kind: ScheduleExceptionMove, OccurrenceAt: occurrence, StartsAt: occurrence.Add(time.Hour), SourceUID: "moved@wiki",
+		})
+		nighthack, err := app.SchedulerService.OverrideNextNighthack(occurrence.Add(3*time.Hour), admin, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nighthack.OccurrenceAt.Equal(occurrence) {
+			t.Errorf("expected the moved occurrence to be overridden, got %+v", nighthack)
+		}
+		saved := exceptions(t, app)
+		if len(saved) != 1 || !saved[0].StartsAt.Equal(occurrence.Add(3*time.Hour)) || saved[0].SourceUID != "" {
+			t.Fatalf("expected the imported exception to become a manual one, got %+v", saved)
+		}
+
+		// the event is gone from the calendar, the manual override is kept
+		plan := PlanICalImport(nil, schedule, time.UTC, saved, now)
+		if len(plan.Changes) != 0 {
+			t.Errorf("expected an import without the event to keep the override, got %+v", plan.Changes)
+		}
+
+		// the calendar still has the event, importing it replaces the override
+		events := []ICalEvent{{UID: "moved@wiki", Summary: "Nighthack", Start: occurrence.Add(time.Hour), End: occurrence.Add(5 * time.Hour)}}
+		plan = PlanICalImport(events, schedule, time.UTC, saved, now)
+		if len(plan.Changes) != 1 || plan.Changes[0].Previous == nil || plan.Changes[0].Remove {
+			t.Fatalf("expected the import to update the override, got %+v", plan.Changes)
+		}
+		if err := app.SchedulerService.ApplyICalImport(plan, admin); err != nil {
+			t.Fatal(err)
+		}
+		saved = exceptions(t, app)
+		if len(saved) != 1 || !saved[0].StartsAt.Equal(occurrence.Add(time.Hour)) || saved[0].SourceUID != "moved@wiki" {
+			t.Errorf("expected the imported event to replace the override, got %+v", saved)
+		}
+	})
+
+	t.Run("an extra nighthack stays extra", func(t *testing.T) {
+		extraAt := time.Date(2024, 2, 29, 18, 0, 0, 0, time.UTC)
+		app, admin := setup(t, &ScheduleException{
+			Kind: ScheduleExceptionExtra, OccurrenceAt: extraAt, StartsAt: extraAt, SourceUID: "extra@wiki",
+		})
+		nighthack, err := app.SchedulerService.OverrideNextNighthack(extraAt.Add(time.Hour), admin, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nighthack.OccurrenceAt.Equal(extraAt) {
+			t.Errorf("expected the extra nighthack to be the next one, got %+v", nighthack)
+		}
+		saved := exceptions(t, app)
+		if len(saved) != 1 || saved[0].Kind != ScheduleExceptionExtra || !saved[0].StartsAt.Equal(extraAt.Add(time.Hour)) {
+			t.Errorf("expected the extra nighthack to be moved, got %+v", saved)
+		}
+		upcoming, err := app.SchedulerService.UpcomingNighthacks(now, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(upcoming) != 2 || !upcoming[0].Extra || !upcoming[1].StartsAt.Equal(occurrence) {
+			t.Errorf("expected the moved extra nighthack before the scheduled one, got %+v", upcoming)
+		}
+	})
+}
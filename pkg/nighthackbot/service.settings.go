@@ -0,0 +1,294 @@
+package nighthackbot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SettingAnnouncementChatID        = "announcement_chat_id"
+	SettingTimezone                  = "timezone"
+	SettingNighthackSchedule         = "nighthack_schedule"
+	SettingCallForVolunteersSchedule = "call_for_volunteers_schedule"
+	SettingNighthackDuration         = "nighthack_duration"
+	SettingKeyholderQuorum           = "keyholder_quorum"
+	SettingVolunteerQuorum           = "volunteer_quorum"
+	SettingDecisionCutoff            = "decision_cutoff"
+)
+
+// SettingDefinition describes a runtime setting which can be changed by admins.
+type SettingDefinition struct {
+	Key         string
+	Description string
+	// Default returns the value used when the setting has not been set yet.
+	Default  func(config *Config) string
+	Validate func(value string) error
+}
+
+var SettingDefinitions = []*SettingDefinition{
+	{
+		Key:         SettingAnnouncementChatID,
+		Description: "chat where calls for volunteers and announcements are posted",
+		Default: func(config *Config) string {
+			return strconv.FormatInt(config.Nighthack.AnnouncementChatID, 10)
+		},
+		Validate: validateInt64Setting,
+	},
+	{
+		Key:         SettingTimezone,
+		Description: "time zone of the space, used for schedules without an explicit time zone",
+		Default: func(config *Config) string {
+			if config.Nighthack.Timezone == "" {
+				return "UTC"
+			}
+			return config.Nighthack.Timezone
+		},
+		Validate: validateLocationSetting,
+	},
+	{
+		Key:         SettingNighthackSchedule,
+		Description: "when the nighthacks take place",
+		Validate:    validateScheduleSetting,
+	},
+	{
+		Key:         SettingCallForVolunteersSchedule,
+		Description: "when the call for volunteers is posted",
+		Validate:    validateScheduleSetting,
+	},
+	{
+		Key:         SettingNighthackDuration,
+		Description: "how long a nighthack lasts",
+		Default: func(config *Config) string {
+			return "6h"
+		},
+		Validate: validateDurationSetting,
+	},
+	{
+		Key:         SettingKeyholderQuorum,
+		Description: "how many volunteers who can open the space are needed for a nighthack",
+		Default: func(config *Config) string {
+			return "1"
+		},
+		Validate: validateNonNegativeIntSetting,
+	},
+	{
+		Key:         SettingVolunteerQuorum,
+		Description: "how many attendees (including those who open the space) are needed for a nighthack",
+		Default: func(config *Config) string {
+			return "1"
+		},
+		Validate: validateNonNegativeIntSetting,
+	},
+	{
+		Key:         SettingDecisionCutoff,
+		Description: "how long before the nighthack it is decided whether it takes place",
+		Default: func(config *Config) string {
+			return "2h"
+		},
+		Validate: validateDurationSetting,
+	},
+}
+
+// SettingsService stores runtime settings as ConfigEntry rows and notifies watchers about changes.
+type SettingsService struct {
+	BotApp *BotApp
+
+	mutex    sync.Mutex
+	cache    map[string]string
+	watchers map[string][]func(value string)
+	// setMutexes make the changes of a setting and the notifications of its watchers happen in the same order
+	setMutexes map[string]*sync.Mutex
+}
+
+func NewSettingsService(botApp *BotApp) *SettingsService {
+	return &SettingsService{
+		BotApp:     botApp,
+		cache:      map[string]string{},
+		watchers:   map[string][]func(value string){},
+		setMutexes: map[string]*sync.Mutex{},
+	}
+}
+
+func (s *SettingsService) Definition(key string) (*SettingDefinition, error) {
+	for _, def := range SettingDefinitions {
+		if def.Key == key {
+			return def, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown setting %q", key)
+}
+
+// Get returns the current value of a setting, falling back to its default.
+func (s *SettingsService) Get(key string) (string, error) {
+	def, err := s.Definition(key)
+	if err != nil {
+		return "", err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if val, ok := s.cache[key]; ok {
+		return val, nil
+	}
+	entry := &ConfigEntry{}
+	if err := s.BotApp.DB.Where(&ConfigEntry{Key: key}).First(entry).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", err
+		}
+		if def.Default != nil {
+			entry.Value = def.Default(s.BotApp.Config)
+		}
+	}
+	s.cache[key] = entry.Value
+	return entry.Value, nil
+}
+
+// Set validates and stores a new value of a setting, then notifies the watchers. The watchers of a setting
+// are notified about concurrent changes in the order the values were stored, they must not set it themselves.
+func (s *SettingsService) Set(key string, value string) error {
+	def, err := s.Definition(key)
+	if err != nil {
+		return err
+	}
+	if def.Validate != nil {
+		if err := def.Validate(value); err != nil {
+			return fmt.Errorf("invalid value for %v: %w", key, err)
+		}
+	}
+	setMutex := s.setMutex(key)
+	setMutex.Lock()
+	defer setMutex.Unlock()
+	watchers, err := func() ([]func(value string), error) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		entry := &ConfigEntry{}
+		if err := s.BotApp.DB.Where(&ConfigEntry{Key: key}).First(entry).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			entry.Key = key
+		}
+		entry.Value = value
+		if err := s.BotApp.DB.Save(entry).Error; err != nil {
+			return nil, err
+		}
+		s.cache[key] = value
+		return append([]func(value string){}, s.watchers[key]...), nil
+	}()
+	if err != nil {
+		return err
+	}
+	for _, watcher := range watchers {
+		watcher(value)
+	}
+	return nil
+}
+
+func (s *SettingsService) setMutex(key string) *sync.Mutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.setMutexes[key] == nil {
+		s.setMutexes[key] = &sync.Mutex{}
+	}
+	return s.setMutexes[key]
+}
+
+// Watch registers a function which is called with the new value every time the setting changes.
+func (s *SettingsService) Watch(key string, watcher func(value string)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.watchers[key] = append(s.watchers[key], watcher)
+}
+
+func (s *SettingsService) GetInt64(key string) (int64, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if val == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+func (s *SettingsService) GetInt(key string) (int, error) {
+	val, err := s.GetInt64(key)
+	return int(val), err
+}
+
+// GetScheduleExpression returns the parsed schedule or nil if the setting is empty.
+func (s *SettingsService) GetScheduleExpression(key string) (*ScheduleExpression, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if val == "" {
+		return nil, nil
+	}
+	return ParseScheduleExpression(val)
+}
+
+// GetDuration parses the setting with time.ParseDuration.
+func (s *SettingsService) GetDuration(key string) (time.Duration, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return time.ParseDuration(val)
+}
+
+func (s *SettingsService) GetLocation(key string) (*time.Location, error) {
+	val, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return time.LoadLocation(val)
+}
+
+func validateInt64Setting(value string) error {
+	if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+		return fmt.Errorf("expected an integer")
+	}
+	return nil
+}
+
+func validateNonNegativeIntSetting(value string) error {
+	val, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("expected an integer")
+	}
+	if val < 0 {
+		return fmt.Errorf("expected a non-negative integer")
+	}
+	return nil
+}
+
+func validateScheduleSetting(value string) error {
+	if value == "" {
+		return nil
+	}
+	_, err := ParseScheduleExpression(value)
+	return err
+}
+
+func validateDurationSetting(value string) error {
+	val, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("expected a duration like 2h30m")
+	}
+	if val < 0 {
+		return fmt.Errorf("expected a non-negative duration")
+	}
+	return nil
+}
+
+func validateLocationSetting(value string) error {
+	if _, err := time.LoadLocation(value); err != nil {
+		return fmt.Errorf("expected a time zone like Europe/Warsaw")
+	}
+	return nil
+}
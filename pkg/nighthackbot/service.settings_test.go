@@ -0,0 +1,138 @@
+package nighthackbot
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSettingsDefaults(t *testing.T) {
+	app := newTestBotApp(t)
+	app.Config.Nighthack.Timezone = "Europe/Warsaw"
+	app.Config.Nighthack.AnnouncementChatID = -100
+
+	for key, expected := range map[string]string{
+		SettingTimezone:           "Europe/Warsaw",
+		SettingAnnouncementChatID: "-100",
+		SettingNighthackDuration:  "6h",
+		SettingDecisionCutoff:     "2h",
+		SettingNighthackSchedule:  "",
+	} {
+		if val, err := app.SettingsService.Get(key); err != nil || val != expected {
+			t.Errorf("expected %v to default to %q, got %q (%v)", key, expected, val, err)
+		}
+	}
+	if duration, err := app.SettingsService.GetDuration(SettingNighthackDuration); err != nil || duration != 6*time.Hour {
+		t.Errorf("expected the duration to default to 6h, got %v (%v)", duration, err)
+	}
+	if schedule, err := app.SettingsService.GetScheduleExpression(SettingNighthackSchedule); err != nil || schedule != nil {
+		t.Errorf("expected no schedule by default, got %v (%v)", schedule, err)
+	}
+	if _, err := app.SettingsService.Get("nope"); err == nil {
+		t.Errorf("expected an unknown setting to fail")
+	}
+}
+
+func TestSettingsValidation(t *testing.T) {
+	cases := []struct {
+		key   string
+		value string
+	}{
+		{SettingAnnouncementChatID, "general"},
+		{SettingTimezone, "Mars/Olympus_Mons"},
+		{SettingNighthackSchedule, "every full moon"},
+		{SettingNighthackDuration, "6 hours"},
+		{SettingDecisionCutoff, "-1h"},
+		{SettingKeyholderQuorum, "-1"},
+		{SettingVolunteerQuorum, "two"},
+		{"nope", "1"},
+	}
+	app := newTestBotApp(t)
+	notified := 0
+	for _, c := range cases {
+		app.SettingsService.Watch(c.key, func(value string) { notified++ })
+	}
+	for _, c := range cases {
+		before, _ := app.SettingsService.Get(c.key)
+		if err := app.SettingsService.Set(c.key, c.value); err == nil {
+			t.Errorf("expected %q to be rejected for %v", c.value, c.key)
+		}
+		if after, _ := app.SettingsService.Get(c.key); after != before {
+			t.Errorf("expected %v to stay %q after a rejected value, got %q", c.key, before, after)
+		}
+	}
+	if notified != 0 {
+		t.Errorf("expected no watcher to be notified about rejected values, got %d notifications", notified)
+	}
+}
+
+func TestSettingsSetNotifiesWatchers(t *testing.T) {
+	app := newTestBotApp(t)
+	// fill the cache with the default first
+	if val, _ := app.SettingsService.Get(SettingKeyholderQuorum); val != "1" {
+		t.Fatalf("expected the default quorum, got %q", val)
+	}
+	values := []string{}
+	app.SettingsService.Watch(SettingKeyholderQuorum, func(value string) {
+		values = append(values, value)
+	})
+	app.SettingsService.Watch(SettingVolunteerQuorum, func(value string) {
+		t.Errorf("expected only the watchers of the changed setting to be notified")
+	})
+
+	if err := app.SettingsService.Set(SettingKeyholderQuorum, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[0] != "2" {
+		t.Errorf("expected the watcher to get the new value, got %v", values)
+	}
+	if val, err := app.SettingsService.GetInt(SettingKeyholderQuorum); err != nil || val != 2 {
+		t.Errorf("expected the cached value to be updated, got %v (%v)", val, err)
+	}
+	if err := app.SettingsService.Set(SettingKeyholderQuorum, "3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the value survives a restart
+	restarted := NewSettingsService(app)
+	if val, err := restarted.Get(SettingKeyholderQuorum); err != nil || val != "3" {
+		t.Errorf("expected the stored value after a restart, got %q (%v)", val, err)
+	}
+	entries := []ConfigEntry{}
+	if err := app.DB.Where(&ConfigEntry{Key: SettingKeyholderQuorum}).Find(&entries).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected setting a value twice to update the entry, got %d entries", len(entries))
+	}
+}
+
+func TestSettingsConcurrentSetsNotifyInOrder(t *testing.T) {
+	app := newTestBotApp(t)
+	var mutex sync.Mutex
+	last := ""
+	app.SettingsService.Watch(SettingKeyholderQuorum, func(value string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		last = value
+	})
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			if err := app.SettingsService.Set(SettingKeyholderQuorum, value); err != nil {
+				t.Error(err)
+			}
+		}(strconv.Itoa(i))
+	}
+	wg.Wait()
+	stored, err := app.SettingsService.Get(SettingKeyholderQuorum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != stored {
+		t.Errorf("expected the watcher to be notified last about the stored value %q, got %q", stored, last)
+	}
+}
@@ -2,7 +2,9 @@ package nighthackbot
 
 import (
 	"errors"
+	"fmt"
 
+	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 )
 
@@ -42,3 +44,62 @@ func (s *UsersService) AddUserToArgs(args *CommandArguments) error {
 
 	return nil
 }
+
+// SetAdmin grants or revokes the admin role of the user with the telegram ID, creating the user if needed.
+// The actor is the admin making the change, nil if it comes from the config or the command line.
+func (s *UsersService) SetAdmin(telegramID int64, isAdmin bool, actor *User, source string) (*User, error) {
+	user := &User{}
+	if err := s.BotApp.DB.Where("telegram_id = ?", telegramID).First(user).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		user.TelegramID = telegramID
+	}
+	if user.IsAdmin == isAdmin && user.ID != "" {
+		return user, nil
+	}
+	user.IsAdmin = isAdmin
+	user.AdminSource = source
+	if err := s.BotApp.DB.Save(user).Error; err != nil {
+		return nil, err
+	}
+
+	// the audit service also logs the change
+	entry := &AuditLogEntry{
+		Action:  AuditActionAdminGranted,
+		Details: fmt.Sprintf("granted admin to %v via %v", user.DisplayName(), source),
+	}
+	if !isAdmin {
+		entry.Action = AuditActionAdminRevoked
+		entry.Details = fmt.Sprintf("revoked admin of %v via %v", user.DisplayName(), source)
+	}
+	if actor != nil {
+		entry.UserID = &actor.ID
+		entry.TelegramID = actor.TelegramID
+	}
+	s.BotApp.AuditService.Record(entry)
+	return user, nil
+}
+
+// BootstrapAdmins makes admins of the users listed in the config. Users whose admin was changed
+// by hand since are left alone, so revoking the admin of a configured user lasts across restarts.
+func (s *UsersService) BootstrapAdmins() error {
+	for _, telegramID := range s.BotApp.Config.Admins {
+		user := &User{}
+		err := s.BotApp.DB.Where("telegram_id = ?", telegramID).First(user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to find admin %d: %w", telegramID, err)
+		}
+		if err == nil && user.AdminSource != "" && user.AdminSource != "config" {
+			if !user.IsAdmin {
+				log.Warn().Int64("telegram_id", telegramID).Str("source", user.AdminSource).
+					Msgf("Not granting admin to a configured user, it was revoked by hand")
+			}
+			continue
+		}
+		if _, err := s.SetAdmin(telegramID, true, nil, "config"); err != nil {
+			return fmt.Errorf("failed to grant admin to %d: %w", telegramID, err)
+		}
+	}
+	return nil
+}
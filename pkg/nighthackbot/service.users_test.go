@@ -0,0 +1,50 @@
+package nighthackbot
+
+import "testing"
+
+func TestBootstrapAdminsKeepsRevokes(t *testing.T) {
+	app := newTestBotApp(t)
+	app.Config.Admins = []int64{1}
+	countEntries := func(action AuditAction) int64 {
+		t.Helper()
+		var count int64
+		if err := app.DB.Model(&AuditLogEntry{}).Where("action = ?", action).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	isAdmin := func() bool {
+		t.Helper()
+		user := &User{}
+		if err := app.DB.Where("telegram_id = ?", 1).First(user).Error; err != nil {
+			t.Fatal(err)
+		}
+		return user.IsAdmin
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := app.UsersService.BootstrapAdmins(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !isAdmin() {
+		t.Fatalf("expected the configured user to be an admin")
+	}
+	if granted := countEntries(AuditActionAdminGranted); granted != 1 {
+		t.Errorf("expected admin to be granted once, got %d audit entries", granted)
+	}
+
+	root := saveTestUser(t, app, &User{TelegramID: 2, Username: "root", IsAdmin: true})
+	if _, err := app.UsersService.SetAdmin(1, false, root, "/admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.UsersService.BootstrapAdmins(); err != nil {
+		t.Fatal(err)
+	}
+	if isAdmin() {
+		t.Errorf("expected the revoked admin to stay revoked after a restart")
+	}
+	if granted := countEntries(AuditActionAdminGranted); granted != 1 {
+		t.Errorf("expected admin not to be granted again, got %d audit entries", granted)
+	}
+}
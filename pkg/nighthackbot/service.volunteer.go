@@ -0,0 +1,175 @@
+package nighthackbot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// VolunteerStatusLabels are the labels of the buttons and the sections of the call for volunteers message.
+var VolunteerStatusLabels = []struct {
+	Status  VolunteerStatus
+	Button  string
+	Section string
+}{
+	{VolunteerStatusOpen, "🔑 I can open", "🔑 Can open"},
+	{VolunteerStatusAttend, "👋 I'll attend", "👋 Attending"},
+	{VolunteerStatusNo, "❌ Can't make it", "❌ Can't make it"},
+}
+
+type VolunteerService struct {
+	BotApp *BotApp
+}
+
+func NewVolunteerService(botApp *BotApp) *VolunteerService {
+	return &VolunteerService{
+		BotApp: botApp,
+	}
+}
+
+// SetStatus stores the answer of the user and updates the call for volunteers message.
+// The answer counts even if the message cannot be edited, for example because it was deleted.
+func (s *VolunteerService) SetStatus(nighthack *Nighthack, user *User, status VolunteerStatus) error {
+	volunteer := &Volunteer{}
+	if err := s.BotApp.DB.Where("nighthack_id = ? AND user_id = ?", nighthack.ID, user.ID).First(volunteer).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		volunteer.NighthackID = nighthack.ID
+		volunteer.UserID = user.ID
+	}
+	if volunteer.ID != "" && volunteer.Status == status {
+		// the message already shows the answer, Telegram refuses edits which do not change it
+		return nil
+	}
+	volunteer.Status = status
+	if err := s.BotApp.DB.Save(volunteer).Error; err != nil {
+		return err
+	}
+	if err := s.UpdateCallMessage(nighthack); err != nil {
+		log.Warn().Err(err).Str("nighthack_id", nighthack.ID).Msgf("Failed to update call for volunteers message")
+	}
+	return nil
+}
+
+// List returns the volunteers of the nighthack with their users.
+func (s *VolunteerService) List(nighthack *Nighthack) ([]Volunteer, error) {
+	volunteers := []Volunteer{}
+	err := s.BotApp.DB.Preload("User").Where("nighthack_id = ?", nighthack.ID).Order("updated_at").Find(&volunteers).Error
+	return volunteers, err
+}
+
+// CallMessage renders the call for volunteers message with the current answers.
+func (s *VolunteerService) CallMessage(nighthack *Nighthack) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	volunteers, err := s.List(nighthack)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	text := fmt.Sprintf(
+		"📣 Next nighthack: <b>%v</b>\n\nWho can open the space?\n",
+		html.EscapeString(s.BotApp.SchedulerService.FormatTime(nighthack.StartsAt)),
+	)
+	keyRegistry, err := s.usesKeyRegistry()
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	buttons := []tgbotapi.InlineKeyboardButton{}
+	for _, label := range VolunteerStatusLabels {
+		names := []string{}
+		for _, volunteer := range volunteers {
+			if volunteer.Status == label.Status && volunteer.User != nil {
+				name := html.EscapeString(volunteer.User.DisplayName())
+				if volunteer.Status == VolunteerStatusOpen && !canOpen(volunteer.User, keyRegistry) {
+					name += " (no key)"
+				}
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			text += fmt.Sprintf("\n%v: %v", label.Section, strings.Join(names, ", "))
+		}
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(
+			label.Button,
+			fmt.Sprintf("/volunteer %v %v", label.Status, nighthack.ID),
+		))
+	}
+	return text, tgbotapi.NewInlineKeyboardMarkup(buttons), nil
+}
+
+// UpdateCallMessage edits the already sent call for volunteers message to show the current answers.
+func (s *VolunteerService) UpdateCallMessage(nighthack *Nighthack) error {
+	if nighthack.CallMessageID == 0 {
+		return nil
+	}
+	text, markup, err := s.CallMessage(nighthack)
+	if err != nil {
+		return err
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(nighthack.CallMessageChatID, nighthack.CallMessageID, text, markup)
+	edit.ParseMode = "HTML"
+	return s.BotApp.Messenger.Edit(edit)
+}
+
+// QuorumResult is the outcome of checking the volunteers of a nighthack against the quorum.
+type QuorumResult struct {
+	Keyholders         int
+	Attendees          int
+	RequiredKeyholders int
+	RequiredAttendees  int
+}
+
+func (r *QuorumResult) Reached() bool {
+	return r.Keyholders >= r.RequiredKeyholders && r.Attendees >= r.RequiredAttendees
+}
+
+func (r *QuorumResult) String() string {
+	return fmt.Sprintf("%d/%d volunteers who can open, %d/%d attendees", r.Keyholders, r.RequiredKeyholders, r.Attendees, r.RequiredAttendees)
+}
+
+// EvaluateQuorum counts the volunteers of the nighthack and compares them with the configured quorum.
+func (s *VolunteerService) EvaluateQuorum(nighthack *Nighthack) (*QuorumResult, error) {
+	result := &QuorumResult{}
+	var err error
+	if result.RequiredKeyholders, err = s.BotApp.SettingsService.GetInt(SettingKeyholderQuorum); err != nil {
+		return nil, err
+	}
+	if result.RequiredAttendees, err = s.BotApp.SettingsService.GetInt(SettingVolunteerQuorum); err != nil {
+		return nil, err
+	}
+	volunteers, err := s.List(nighthack)
+	if err != nil {
+		return nil, err
+	}
+	keyRegistry, err := s.usesKeyRegistry()
+	if err != nil {
+		return nil, err
+	}
+	for _, volunteer := range volunteers {
+		switch volunteer.Status {
+		case VolunteerStatusOpen:
+			if canOpen(volunteer.User, keyRegistry) {
+				result.Keyholders++
+			}
+			result.Attendees++
+		case VolunteerStatusAttend:
+			result.Attendees++
+		}
+	}
+	return result, nil
+}
+
+// usesKeyRegistry reports whether any keys are registered. Until then everyone who says they can open is trusted.
+func (s *VolunteerService) usesKeyRegistry() (bool, error) {
+	count, err := s.BotApp.KeysService.Count()
+	return count > 0, err
+}
+
+// canOpen reports whether the "I can open" answer of the user counts towards the keyholder quorum.
+func canOpen(user *User, keyRegistry bool) bool {
+	return !keyRegistry || (user != nil && user.IsKeyholder)
+}
@@ -0,0 +1,166 @@
+package nighthackbot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// webhookSecretHeader is the header Telegram sends the secret token of the webhook in
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// webhookSecretPattern are the secret tokens accepted by Telegram
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// webhookReadTimeout limits how long a request of Telegram may take to arrive
+const webhookReadTimeout = 30 * time.Second
+
+// allowedUpdates are the kinds of updates the bot receives, both with long polling and the webhook
+var allowedUpdates = []string{"message", "inline_query", "callback_query", "edited_message"}
+
+// WebhookService receives the updates pushed by Telegram to the webhook.
+// They are passed to the same channel the long polling would use.
+type WebhookService struct {
+	BotApp  *BotApp
+	Updates chan tgbotapi.Update
+
+	secretToken string
+	server      *http.Server
+}
+
+func NewWebhookService(botApp *BotApp) *WebhookService {
+	return &WebhookService{
+		BotApp:  botApp,
+		Updates: make(chan tgbotapi.Update, 100),
+	}
+}
+
+// Enabled reports whether the webhook mode is configured.
+func (s *WebhookService) Enabled() bool {
+	return s.BotApp.Config.Telegram.Webhook.Listen != ""
+}
+
+// Start listens for the updates and registers the webhook with Telegram.
+func (s *WebhookService) Start() (tgbotapi.UpdatesChannel, error) {
+	config := s.BotApp.Config.Telegram.Webhook
+	publicURL, err := url.Parse(config.PublicURL)
+	if err != nil || publicURL.Scheme != "https" {
+		return nil, fmt.Errorf("the webhook public url must be a https url, got %q", config.PublicURL)
+	}
+	s.secretToken = config.SecretToken
+	if s.secretToken == "" {
+		// the webhook is registered again on every start, so a random token works
+		if s.secretToken, err = randomWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	if !webhookSecretPattern.MatchString(s.secretToken) {
+		return nil, fmt.Errorf("the webhook secret token can only contain A-Z, a-z, 0-9, _ and - and be at most 256 characters long")
+	}
+
+	path := publicURL.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{
+		Addr:              config.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: webhookReadTimeout,
+		ReadTimeout:       webhookReadTimeout,
+	}
+	go func() {
+		log.Info().Str("listen", config.Listen).Str("path", path).Msgf("Webhook server started")
+		var err error
+		if config.CertFile != "" {
+			err = s.server.ListenAndServeTLS(config.CertFile, config.KeyFile)
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msgf("Webhook server failed")
+		}
+	}()
+
+	if err := s.register(publicURL.String()); err != nil {
+		return nil, fmt.Errorf("failed to set webhook: %w", err)
+	}
+	log.Info().Str("url", publicURL.String()).Msgf("Webhook registered")
+	return s.Updates, nil
+}
+
+// Stop stops accepting updates. The webhook stays registered, Telegram keeps the updates until the bot is back.
+func (s *WebhookService) Stop(ctx context.Context) {
+	if s.server == nil {
+		return
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msgf("Failed to shut down webhook server")
+	}
+}
+
+// register calls setWebhook. The secret token is not supported by WebhookConfig, so the request is made directly.
+func (s *WebhookService) register(webhookURL string) error {
+	params := tgbotapi.Params{}
+	params["url"] = webhookURL
+	params["secret_token"] = s.secretToken
+	if err := params.AddInterface("allowed_updates", allowedUpdates); err != nil {
+		return err
+	}
+	certFile := s.BotApp.Config.Telegram.Webhook.CertFile
+	if certFile == "" {
+		_, err := s.BotApp.Bot.MakeRequest("setWebhook", params)
+		return err
+	}
+	_, err := s.BotApp.Bot.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{{
+		Name: "certificate",
+		Data: tgbotapi.FilePath(certFile),
+	}})
+	return err
+}
+
+// ServeHTTP accepts an update posted by Telegram.
+func (s *WebhookService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secret := r.Header.Get(webhookSecretHeader)
+	if s.secretToken == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(s.secretToken)) != 1 {
+		log.Warn().Str("remote_addr", r.RemoteAddr).Msgf("Rejected webhook request with a wrong secret token")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	update := tgbotapi.Update{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	select {
+	case s.Updates <- update:
+	default:
+		// the bot is not keeping up, Telegram sends the update again later
+		log.Warn().Int("update_id", update.UpdateID).Msgf("Rejected webhook update, too many updates are waiting")
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+func randomWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
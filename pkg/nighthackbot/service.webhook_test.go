@@ -0,0 +1,72 @@
+package nighthackbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebhookServeHTTP(t *testing.T) {
+	s := NewWebhookService(NewBotApp())
+	s.secretToken = "secret"
+	body := `{"update_id": 1, "message": {"message_id": 2, "chat": {"id": 3, "type": "private"}, "from": {"id": 4}, "text": "/start"}}`
+
+	tests := []struct {
+		method string
+		secret string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "secret", body, http.StatusOK},
+		{http.MethodPost, "wrong", body, http.StatusForbidden},
+		{http.MethodPost, "", body, http.StatusForbidden},
+		{http.MethodGet, "secret", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "secret", "not json", http.StatusBadRequest},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest(test.method, "/telegram", strings.NewReader(test.body))
+		if test.secret != "" {
+			req.Header.Set(webhookSecretHeader, test.secret)
+		}
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%d: expected status %d, got %d", i, test.status, rec.Code)
+		}
+	}
+
+	if len(s.Updates) != 1 {
+		t.Fatalf("expected one update to be accepted, got %d", len(s.Updates))
+	}
+	update := <-s.Updates
+	if update.UpdateID != 1 || update.Message == nil || update.Message.Text != "/start" || update.Message.Chat.Type != "private" {
+		t.Errorf("unexpected update %+v", update)
+	}
+}
+
+func TestWebhookServeHTTPWhenFull(t *testing.T) {
+	s := NewWebhookService(NewBotApp())
+	s.secretToken = "secret"
+	// nobody receives the updates
+	s.Updates = make(chan tgbotapi.Update)
+	req := httptest.NewRequest(http.MethodPost, "/telegram", strings.NewReader(`{"update_id": 1}`))
+	req.Header.Set(webhookSecretHeader, "secret")
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.ServeHTTP(rec, req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request not to wait for the update to be received")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected a full queue to answer %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+}
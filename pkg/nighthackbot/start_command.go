@@ -31,6 +31,10 @@ func (s *StartCommand) Execute(ctx context.Context, args *CommandArguments) erro
 	extraHelp := ""
 
 	for _, cmd := range s.App.Commands {
+		if CommandRequirementsOf(cmd).Check(args.User, args.ChatType) != nil {
+			// only list the commands the user can execute here
+			continue
+		}
 		line := html.EscapeString(cmd.Aliases()[0])
 		for _, arg := range cmd.Arguments() {
 			line += fmt.Sprintf(" [%s]", arg.Name)
@@ -54,6 +58,6 @@ Available commands:
 %v
 `, s.App.BotName, commandHelp, extraHelp))
 	msg.ParseMode = "HTML"
-	_, err := s.App.Bot.Send(msg)
+	_, err := s.App.Messenger.Send(msg)
 	return err
 }
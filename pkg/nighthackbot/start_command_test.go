@@ -0,0 +1,27 @@
+package nighthackbot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStartCommandListsAllowedCommands(t *testing.T) {
+	app, messenger := newFakeBotApp(t)
+	user := &User{TelegramID: 10, Username: "alice"}
+	admin := saveTestUser(t, app, &User{TelegramID: 11, Username: "root", IsAdmin: true})
+
+	newFakeChat(app, user.TelegramID, "private").send(user, "/start")
+	sent := messenger.Sent(user.TelegramID)
+	if len(sent) != 1 {
+		t.Fatalf("expected one message, got %d", len(sent))
+	}
+	if !strings.Contains(sent[0].Text, "/volunteer") || strings.Contains(sent[0].Text, "/admin") {
+		t.Errorf("expected the commands of a regular user, got %q", sent[0].Text)
+	}
+
+	newFakeChat(app, admin.TelegramID, "private").send(admin, "/start")
+	sent = messenger.Sent(admin.TelegramID)
+	if len(sent) != 1 || !strings.Contains(sent[0].Text, "/admin") {
+		t.Errorf("expected the admin commands to be listed for an admin, got %+v", sent)
+	}
+}
@@ -0,0 +1,118 @@
+package nighthackbot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Token is a single word of a command.
+type Token struct {
+	Value string
+	// Flag is the name of a --flag=value token, empty for positional tokens.
+	Flag string
+	// Start is the byte offset of the token in the command text and End the offset after it.
+	Start int
+	End   int
+}
+
+// TokenizeCommand splits the text of a command into tokens like a shell does:
+// whitespace (including newlines) separates the tokens, double quotes group words and allow backslash escapes,
+// single quotes group words literally and a backslash outside of quotes escapes the next character.
+// Tokens like --name=value (or --name, which means "true") are flags, a lone -- makes the rest positional.
+func TokenizeCommand(src string) ([]Token, error) {
+	tokens := []Token{}
+	flagsEnabled := true
+	i := 0
+	for {
+		// skip the whitespace between the tokens
+		for i < len(src) {
+			r, size := utf8.DecodeRuneInString(src[i:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			i += size
+		}
+		if i >= len(src) {
+			return tokens, nil
+		}
+
+		start := i
+		value := strings.Builder{}
+		quoted := false
+		for i < len(src) {
+			r, size := utf8.DecodeRuneInString(src[i:])
+			if unicode.IsSpace(r) {
+				break
+			}
+			switch r {
+			case '\\':
+				if i+1 >= len(src) {
+					return nil, fmt.Errorf("nothing to escape at the end of the command")
+				}
+				next, nextSize := utf8.DecodeRuneInString(src[i+1:])
+				value.WriteRune(next)
+				i += 1 + nextSize
+			case '"':
+				quoted = true
+				end, err := readDoubleQuoted(src, i+1, &value)
+				if err != nil {
+					return nil, err
+				}
+				i = end
+			case '\'':
+				quoted = true
+				end := strings.IndexByte(src[i+1:], '\'')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated ' quote starting at %d", i)
+				}
+				value.WriteString(src[i+1 : i+1+end])
+				i += end + 2
+			default:
+				value.WriteRune(r)
+				i += size
+			}
+		}
+
+		token := Token{Value: value.String(), Start: start, End: i}
+		raw := src[start:i]
+		if flagsEnabled && !quoted && raw == "--" {
+			flagsEnabled = false
+			continue
+		}
+		// only an unquoted --name starts a flag, its value may be quoted
+		if flagsEnabled && strings.HasPrefix(raw, "--") && len(raw) > 2 {
+			name, flagValue, hasValue := strings.Cut(token.Value[2:], "=")
+			nameEnd := strings.IndexAny(raw[2:], `="'\`)
+			if name != "" && (nameEnd < 0 || raw[2+nameEnd] == '=') {
+				token.Flag = name
+				token.Value = "true"
+				if hasValue {
+					token.Value = flagValue
+				}
+			}
+		}
+		tokens = append(tokens, token)
+	}
+}
+
+// readDoubleQuoted reads a double quoted string starting after the opening quote and returns the offset after the closing one.
+func readDoubleQuoted(src string, i int, value *strings.Builder) (int, error) {
+	start := i - 1
+	for i < len(src) {
+		switch src[i] {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			if i+1 < len(src) && strings.IndexByte(`"\`, src[i+1]) >= 0 {
+				value.WriteByte(src[i+1])
+				i += 2
+				continue
+			}
+		}
+		value.WriteByte(src[i])
+		i++
+	}
+	return 0, fmt.Errorf("unterminated \" quote starting at %d", start)
+}
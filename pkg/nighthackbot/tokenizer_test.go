@@ -0,0 +1,140 @@
+package nighthackbot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeCommand(t *testing.T) {
+	tests := []struct {
+		src      string
+		values   []string
+		flags    map[string]string
+		hasError bool
+	}{
+		{src: "/schedule 5", values: []string{"/schedule", "5"}},
+		{src: "  /schedule   5  ", values: []string{"/schedule", "5"}},
+		{src: "/cmd\n\tfirst\nsecond", values: []string{"/cmd", "first", "second"}},
+		{src: `/cmd "two words" 'single quoted'`, values: []string{"/cmd", "two words", "single quoted"}},
+		{src: `/cmd "" ''`, values: []string{"/cmd", "", ""}},
+		{src: `/cmd "say \"hi\"" 'no \escape'`, values: []string{"/cmd", `say "hi"`, `no \escape`}},
+		{src: `/cmd back\ slash \"quote`, values: []string{"/cmd", "back slash", `"quote`}},
+		{src: `/cmd pre"fix and"post`, values: []string{"/cmd", "prefix andpost"}},
+		{src: "/cmd zażółć „gęślą”", values: []string{"/cmd", "zażółć", "„gęślą”"}},
+		{
+			src:    `/admin --command=settings --note="a b" --dry-run pos`,
+			values: []string{"/admin", "pos"},
+			flags:  map[string]string{"command": "settings", "note": "a b", "dry-run": "true"},
+		},
+		{src: `/cmd --flag= x`, values: []string{"/cmd", "x"}, flags: map[string]string{"flag": ""}},
+		{src: `/cmd -- --not-a-flag`, values: []string{"/cmd", "--not-a-flag"}},
+		{src: `/cmd "--quoted" --"x" --=y`, values: []string{"/cmd", "--quoted", "--x", "--=y"}},
+		{src: `/cmd "unterminated`, hasError: true},
+		{src: `/cmd 'unterminated`, hasError: true},
+		{src: `/cmd trailing\`, hasError: true},
+		{src: "", values: []string{}},
+	}
+	for _, test := range tests {
+		tokens, err := TokenizeCommand(test.src)
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %+v", test.src, tokens)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		values := []string{}
+		flags := map[string]string{}
+		for _, token := range tokens {
+			if token.Flag != "" {
+				flags[token.Flag] = token.Value
+			} else {
+				values = append(values, token.Value)
+			}
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%q: expected values %q, got %q", test.src, test.values, values)
+		}
+		if test.flags == nil {
+			test.flags = map[string]string{}
+		}
+		if !reflect.DeepEqual(flags, test.flags) {
+			t.Errorf("%q: expected flags %v, got %v", test.src, test.flags, flags)
+		}
+	}
+}
+
+func TestTokenizeCommandOffsets(t *testing.T) {
+	src := `/cmd "a b"  --x=1 c`
+	tokens, err := TokenizeCommand(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := []string{}
+	for _, token := range tokens {
+		raw = append(raw, src[token.Start:token.End])
+	}
+	if expected := []string{"/cmd", `"a b"`, "--x=1", "c"}; !reflect.DeepEqual(raw, expected) {
+		t.Errorf("expected the tokens to span %q, got %q", expected, raw)
+	}
+}
+
+type testArgumentsCommand struct {
+	StartCommand
+	args []*CommandDefArgument
+}
+
+func (c *testArgumentsCommand) Arguments() []*CommandDefArgument {
+	return c.args
+}
+
+func TestAssignArguments(t *testing.T) {
+	command := &testArgumentsCommand{args: []*CommandDefArgument{
+		{Name: "name"},
+		{Name: "count"},
+		{Name: "message", Variadic: true},
+	}}
+	tests := []struct {
+		src      string
+		expected map[string]string
+		hasError bool
+	}{
+		{src: "/cmd", expected: map[string]string{}},
+		{src: "/cmd  alice", expected: map[string]string{"name": "alice"}},
+		{src: `/cmd --count=3 "alice smith" hello`, expected: map[string]string{"name": "alice smith", "count": "3", "message": "hello"}},
+		{src: `/cmd alice 3 "quoted message"`, expected: map[string]string{"name": "alice", "count": "3", "message": "quoted message"}},
+		{
+			src:      "/cmd alice 3 first line\n  second \"line\"\n",
+			expected: map[string]string{"name": "alice", "count": "3", "message": "first line\n  second line"},
+		},
+		{src: `/cmd alice 3 "a b" 'c'`, expected: map[string]string{"name": "alice", "count": "3", "message": "a b c"}},
+		{src: `/cmd 3 hello --name=bob world`, expected: map[string]string{"name": "bob", "count": "3", "message": "hello world"}},
+		{src: `/cmd 3 "quoted message" --name=bob`, expected: map[string]string{"name": "bob", "count": "3", "message": "quoted message"}},
+		{src: `/cmd alice 3 a -- --b`, expected: map[string]string{"name": "alice", "count": "3", "message": "a --b"}},
+		{src: "/cmd --unknown=1", hasError: true},
+	}
+	for _, test := range tests {
+		tokens, err := TokenizeCommand(test.src)
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		args := &CommandArguments{Command: command, namedArguments: map[string]string{}}
+		err = args.assignArguments(test.src, tokens[1:])
+		if test.hasError {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.src)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(args.namedArguments, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.src, test.expected, args.namedArguments)
+		}
+	}
+}
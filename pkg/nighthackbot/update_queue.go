@@ -0,0 +1,286 @@
+package nighthackbot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultUpdateWorkers   = 8
+	defaultUpdateQueueSize = 100
+)
+
+// UpdateProcessor processes a single update, replaying the recorded answers of a resumed conversation.
+type UpdateProcessor func(ctx context.Context, update tgbotapi.Update, replay []string)
+
+// UpdateQueue processes the updates of a chat one after another in the order they arrived
+// and the updates of different chats in parallel, with at most Workers updates processed at once.
+// At most QueueSize updates wait for a worker, Enqueue blocks when the queue is full,
+// which makes Telegram hold the following updates.
+//
+// A command waiting for an answer gives its worker back (see yieldUpdateWorker),
+// so the answer and the other updates of the chat are processed in the meantime.
+// Once answered it waits for a worker and its chat again, before the updates queued in the meantime.
+type UpdateQueue struct {
+	Workers   int
+	QueueSize int
+
+	process UpdateProcessor
+	// workers and slots are semaphores of the running and the queued updates
+	workers chan struct{}
+	slots   chan struct{}
+	mutex   sync.Mutex
+	chats   map[int64]*chatQueue
+	pending sync.WaitGroup
+
+	processed atomic.Uint64
+	blocked   atomic.Uint64
+	wait      atomic.Int64
+	maxWait   atomic.Int64
+}
+
+// chatQueue are the updates of a chat waiting to be processed.
+type chatQueue struct {
+	updates []queuedUpdate
+	// resumed are the yielded updates which continue before the queued ones
+	resumed []resumedWorker
+}
+
+type resumedWorker struct {
+	worker  *updateWorker
+	granted chan struct{}
+}
+
+type queuedUpdate struct {
+	ctx        context.Context
+	update     tgbotapi.Update
+	replay     []string
+	enqueuedAt time.Time
+}
+
+// UpdateQueueStats are the counters of an UpdateQueue.
+type UpdateQueueStats struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue_size"`
+	// Queued updates are waiting for a worker, Running ones are being processed
+	Queued  int `json:"queued"`
+	Running int `json:"running"`
+	// Processed is the number of updates processed since the start
+	Processed uint64 `json:"processed"`
+	// Blocked is how many times the queue was full and receiving the updates had to wait
+	Blocked uint64 `json:"blocked"`
+	// QueueWait is the total and MaxQueueWait the longest time updates spent waiting for a worker
+	QueueWait    time.Duration `json:"queue_wait_ns"`
+	MaxQueueWait time.Duration `json:"max_queue_wait_ns"`
+}
+
+// NewUpdateQueue returns a queue processing the updates with the process function, zero values use the defaults.
+func NewUpdateQueue(workers int, queueSize int, process UpdateProcessor) *UpdateQueue {
+	if workers <= 0 {
+		workers = defaultUpdateWorkers
+	}
+	if queueSize <= 0 {
+		queueSize = defaultUpdateQueueSize
+	}
+	return &UpdateQueue{
+		Workers:   workers,
+		QueueSize: queueSize,
+		process:   process,
+		workers:   make(chan struct{}, workers),
+		slots:     make(chan struct{}, queueSize),
+		chats:     map[int64]*chatQueue{},
+	}
+}
+
+// Enqueue adds the update to the queue of its chat, waiting for a free place until the context is done.
+// The update is processed with the context.
+func (q *UpdateQueue) Enqueue(ctx context.Context, update tgbotapi.Update, replay []string) error {
+	return q.enqueue(ctx, ctx, update, replay)
+}
+
+// enqueue is Enqueue waiting for a free place until waitCtx is done.
+func (q *UpdateQueue) enqueue(waitCtx context.Context, ctx context.Context, update tgbotapi.Update, replay []string) error {
+	select {
+	case q.slots <- struct{}{}:
+	default:
+		q.blocked.Add(1)
+		log.Warn().Int("queue_size", q.QueueSize).Msgf("Update queue is full, waiting for the running commands")
+		select {
+		case q.slots <- struct{}{}:
+		case <-waitCtx.Done():
+			return waitCtx.Err()
+		}
+	}
+	q.pending.Add(1)
+
+	chatID := updateChatID(update)
+	q.mutex.Lock()
+	chat, running := q.chats[chatID]
+	if !running {
+		chat = &chatQueue{}
+		q.chats[chatID] = chat
+	}
+	chat.updates = append(chat.updates, queuedUpdate{ctx: ctx, update: update, replay: replay, enqueuedAt: time.Now()})
+	q.mutex.Unlock()
+	if !running {
+		go q.runChat(chatID)
+	}
+	return nil
+}
+
+// runChat processes the updates of the chat until its queue is empty.
+func (q *UpdateQueue) runChat(chatID int64) {
+	for {
+		q.mutex.Lock()
+		chat := q.chats[chatID]
+		if len(chat.resumed) > 0 {
+			resumed := chat.resumed[0]
+			chat.resumed = chat.resumed[1:]
+			q.mutex.Unlock()
+			q.workers <- struct{}{}
+			released := resumed.worker.acquire()
+			close(resumed.granted)
+			<-released
+			continue
+		}
+		if len(chat.updates) == 0 {
+			delete(q.chats, chatID)
+			q.mutex.Unlock()
+			return
+		}
+		item := chat.updates[0]
+		chat.updates = chat.updates[1:]
+		q.mutex.Unlock()
+
+		q.workers <- struct{}{}
+		<-q.slots
+		q.recordWait(time.Since(item.enqueuedAt))
+
+		// the next update of the chat waits until this one is processed or yields its worker
+		worker := &updateWorker{queue: q, chatID: chatID}
+		released := worker.acquire()
+		go func() {
+			defer q.pending.Done()
+			defer q.processed.Add(1)
+			defer worker.release()
+			q.process(context.WithValue(item.ctx, updateWorkerKey{}, worker), item.update, item.replay)
+		}()
+		<-released
+	}
+}
+
+// updateWorker is the worker and the chat held by an update while it is processed.
+type updateWorker struct {
+	queue  *UpdateQueue
+	chatID int64
+	mutex  sync.Mutex
+	// released is closed when the update gives the worker back, nil while it does not hold one
+	released chan struct{}
+}
+
+// acquire marks the worker as held and returns the channel closed when it is released.
+func (w *updateWorker) acquire() chan struct{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.released = make(chan struct{})
+	return w.released
+}
+
+// release gives the worker back and lets the chat continue with its next update.
+func (w *updateWorker) release() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.released == nil {
+		return
+	}
+	<-w.queue.workers
+	close(w.released)
+	w.released = nil
+}
+
+// reacquire waits until the chat and a worker are free again, the chat continues with it before its queued updates.
+func (w *updateWorker) reacquire() {
+	q := w.queue
+	granted := make(chan struct{})
+	q.mutex.Lock()
+	chat, running := q.chats[w.chatID]
+	if !running {
+		chat = &chatQueue{}
+		q.chats[w.chatID] = chat
+	}
+	chat.resumed = append(chat.resumed, resumedWorker{worker: w, granted: granted})
+	q.mutex.Unlock()
+	if !running {
+		go q.runChat(w.chatID)
+	}
+	<-granted
+}
+
+func (q *UpdateQueue) recordWait(wait time.Duration) {
+	q.wait.Add(int64(wait))
+	for {
+		max := q.maxWait.Load()
+		if int64(wait) <= max || q.maxWait.CompareAndSwap(max, int64(wait)) {
+			return
+		}
+	}
+}
+
+// Wait waits until all the queued updates have been processed or the timeout passes and reports whether they were.
+func (q *UpdateQueue) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Stats returns the current counters of the queue.
+func (q *UpdateQueue) Stats() UpdateQueueStats {
+	return UpdateQueueStats{
+		Workers:      q.Workers,
+		QueueSize:    q.QueueSize,
+		Queued:       len(q.slots),
+		Running:      len(q.workers),
+		Processed:    q.processed.Load(),
+		Blocked:      q.blocked.Load(),
+		QueueWait:    time.Duration(q.wait.Load()),
+		MaxQueueWait: time.Duration(q.maxWait.Load()),
+	}
+}
+
+type updateWorkerKey struct{}
+
+// yieldUpdateWorker lets the queue process the next updates of the chat while the update of the context
+// is waiting, for example for an answer. The returned function waits until the update can continue and has to be
+// called before it does anything else. Both do nothing if the update is not processed by an UpdateQueue.
+func yieldUpdateWorker(ctx context.Context) (reacquire func()) {
+	worker, ok := ctx.Value(updateWorkerKey{}).(*updateWorker)
+	if !ok {
+		return func() {}
+	}
+	worker.release()
+	return worker.reacquire
+}
+
+// updateChatID returns the chat the update belongs to, updates without a chat share the chat 0.
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.Chat != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	}
+	return 0
+}
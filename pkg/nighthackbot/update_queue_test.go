@@ -0,0 +1,164 @@
+package nighthackbot
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func queueTestUpdate(chatID int64, messageID int) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+func TestUpdateQueueOrdersUpdatesOfAChat(t *testing.T) {
+	var mutex sync.Mutex
+	processed := map[int64][]int{}
+	blockChat := make(chan struct{})
+	queue := NewUpdateQueue(2, 10, func(ctx context.Context, update tgbotapi.Update, replay []string) {
+		if update.Message.Chat.ID == 1 && update.Message.MessageID == 1 {
+			<-blockChat
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		processed[update.Message.Chat.ID] = append(processed[update.Message.Chat.ID], update.Message.MessageID)
+	})
+
+	for i := 1; i <= 3; i++ {
+		if err := queue.Enqueue(context.Background(), queueTestUpdate(1, i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.Enqueue(context.Background(), queueTestUpdate(2, 1), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other chat is not blocked by the first one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		done := len(processed[2]) == 1
+		first := len(processed[1])
+		mutex.Unlock()
+		if first != 0 {
+			t.Fatalf("the updates of chat 1 were processed before the first one finished")
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the update of chat 2 was not processed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := queue.Stats(); stats.Running != 1 || stats.Queued != 2 {
+		t.Errorf("expected 1 running and 2 queued updates, got %+v", stats)
+	}
+
+	close(blockChat)
+	if !queue.Wait(5 * time.Second) {
+		t.Fatalf("the updates were not processed")
+	}
+	if got := processed[1]; len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("expected the updates of chat 1 in order, got %v", got)
+	}
+	if stats := queue.Stats(); stats.Processed != 4 || stats.Running != 0 || stats.Queued != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestUpdateQueueYieldsWaitingUpdates(t *testing.T) {
+	answer := make(chan int, 1)
+	result := make(chan int, 1)
+	queue := NewUpdateQueue(1, 10, func(ctx context.Context, update tgbotapi.Update, replay []string) {
+		if update.Message.MessageID == 1 {
+			// like a question waiting for the answer in the next update
+			reacquire := yieldUpdateWorker(ctx)
+			got := <-answer
+			reacquire()
+			result <- got
+			return
+		}
+		answer <- update.Message.MessageID
+	})
+	queue.Enqueue(context.Background(), queueTestUpdate(1, 1), nil)
+	queue.Enqueue(context.Background(), queueTestUpdate(1, 2), nil)
+	select {
+	case got := <-result:
+		if got != 2 {
+			t.Errorf("expected the answer from the second update, got %v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the waiting update blocked the chat")
+	}
+}
+
+func TestUpdateQueueReacquiresWorkerAfterYield(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	enter := func() {
+		n := running.Add(1)
+		for max := maxRunning.Load(); n > max && !maxRunning.CompareAndSwap(max, n); max = maxRunning.Load() {
+		}
+	}
+	answers := map[int64]chan struct{}{1: make(chan struct{}), 2: make(chan struct{})}
+	queue := NewUpdateQueue(1, 10, func(ctx context.Context, update tgbotapi.Update, replay []string) {
+		enter()
+		defer running.Add(-1)
+		answer := answers[update.Message.Chat.ID]
+		if update.Message.MessageID != 1 {
+			if update.Message.MessageID == 2 {
+				close(answer)
+			}
+			return
+		}
+		// a command asking a question in each chat
+		running.Add(-1)
+		reacquire := yieldUpdateWorker(ctx)
+		<-answer
+		reacquire()
+		enter()
+		// gives the other command the time to continue at the same time
+		time.Sleep(50 * time.Millisecond)
+	})
+	for _, update := range []tgbotapi.Update{
+		queueTestUpdate(1, 1), queueTestUpdate(2, 1), queueTestUpdate(1, 2), queueTestUpdate(2, 2), queueTestUpdate(1, 3),
+	} {
+		if err := queue.Enqueue(context.Background(), update, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !queue.Wait(5 * time.Second) {
+		t.Fatalf("the updates were not processed")
+	}
+	if max := maxRunning.Load(); max != 1 {
+		t.Errorf("expected at most 1 update to run with 1 worker, got %d", max)
+	}
+}
+
+func TestUpdateQueueBlocksWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	queue := NewUpdateQueue(1, 1, func(ctx context.Context, update tgbotapi.Update, replay []string) {
+		<-release
+	})
+	defer close(release)
+	queue.Enqueue(context.Background(), queueTestUpdate(1, 1), nil)
+	// wait until the first update is running, so the second one takes the only place in the queue
+	for queue.Stats().Running == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := queue.Enqueue(context.Background(), queueTestUpdate(1, 2), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := queue.Enqueue(ctx, queueTestUpdate(2, 1), nil); err == nil {
+		t.Fatalf("expected the full queue to block until the context is done")
+	}
+	if stats := queue.Stats(); stats.Blocked != 1 {
+		t.Errorf("expected the full queue to be counted, got %+v", stats)
+	}
+}